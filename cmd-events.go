@@ -0,0 +1,237 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/urfave/cli"
+)
+
+// eventTypes Events that can be watched (alias names are accepted by --type option)
+var eventTypes = []string{
+	xaapiv1.EVTServerConfig,
+	xaapiv1.EVTProjectAdd,
+	xaapiv1.EVTProjectDelete,
+	xaapiv1.EVTProjectChange,
+	xaapiv1.EVTSDKInstall,
+	xaapiv1.EVTSDKRemove,
+}
+
+var eventTypeAliases = map[string]string{
+	"project-change": xaapiv1.EVTProjectChange,
+}
+
+func initCmdEvents(cmdDef *[]cli.Command) {
+	*cmdDef = append(*cmdDef, cli.Command{
+		Name:     "events",
+		Aliases:  []string{"evt"},
+		HideHelp: true,
+		Usage:    "events commands group",
+		Subcommands: []cli.Command{
+			{
+				Name:  "watch",
+				Usage: "Print events sent by XDS agent until interrupted",
+				Description: "One line is printed per event, use --output json to get one json object per line.\n" +
+					"   Supported event types: " + strings.Join(eventTypes, ", ") + " (or project-change).",
+				Action: eventsWatch,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "type, t",
+						Usage: "comma separated list of watched event types (default all)",
+					},
+					cli.StringFlag{
+						Name:  "project, p",
+						Usage: "only print project events of this project (id, unique id prefix, or label)",
+					},
+				},
+			},
+		},
+	})
+}
+
+func eventsWatch(ctx *cli.Context) error {
+	spec, err := OutputSpecGet(ctx)
+	if err != nil {
+		return err
+	}
+	asJSON := spec.Format == "json"
+
+	types, err := _eventTypesParse(ctx.String("type"))
+	if err != nil {
+		return err
+	}
+
+	prjID := ""
+	if ctx.String("project") != "" {
+		if prjID, err = ProjectIDResolve(ctx.String("project")); err != nil {
+			return ExitErrorHTTP(err)
+		}
+	}
+
+	reconnectTimeout, err := ParseTimeout(ctx.GlobalString("reconnect-timeout"))
+	if err != nil {
+		return cli.NewExitError("--reconnect-timeout: "+err.Error(), ExitCodeInvalidArgs)
+	}
+	disconnChan := make(chan error, 1)
+	IOskOn("disconnection", func(err error) {
+		Log.Debugf("WS disconnection event with err: %v\n", err)
+		select {
+		case disconnChan <- err:
+		default:
+		}
+	})
+
+	var outLock sync.Mutex
+	for _, evType := range types {
+		IOskOn(evType, func(ev xaapiv1.EventMsg) {
+			data, id, line := _eventDecode(ev)
+			if !_eventProjectMatch(ev.Type, id, prjID) {
+				return
+			}
+
+			outLock.Lock()
+			defer outLock.Unlock()
+			if asJSON {
+				b, err := json.Marshal(map[string]interface{}{
+					"time":      ev.Time,
+					"type":      ev.Type,
+					"sessionID": ev.FromSessionID,
+					"data":      data,
+				})
+				if err != nil {
+					Log.Warningf("Cannot encode event %s: %v", ev.Type, err)
+					return
+				}
+				fmt.Println(string(b))
+			} else {
+				fmt.Printf("%s %-20s %s\n", ev.Time, ev.Type, line)
+			}
+		})
+		if err := IOskEventRegister(evType); err != nil {
+			return cli.NewExitError(err, ExitCodeConnection)
+		}
+	}
+	Log.Infof("Watching events: %s", strings.Join(types, ", "))
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-sigs:
+			return nil
+
+		case err := <-disconnChan:
+			if reconnectTimeout == 0 {
+				return cli.NewExitError(fmt.Sprintf("connection to XDS agent lost: %v", err), ExitCodeConnection)
+			}
+			fmt.Fprintf(os.Stderr, "WARNING: connection to XDS agent lost, trying to reconnect...\n")
+			if err := IOskReconnect(reconnectTimeout); err != nil {
+				return cli.NewExitError(err.Error(), ExitCodeConnection)
+			}
+			fmt.Fprintf(os.Stderr, "Reconnected to XDS agent, events may have been missed.\n")
+		}
+	}
+}
+
+// _eventTypesParse Return types of a comma separated list of event types or
+// aliases, all types when list is empty
+func _eventTypesParse(list string) ([]string, error) {
+	if list == "" {
+		return eventTypes, nil
+	}
+	types := []string{}
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if alias, ok := eventTypeAliases[t]; ok {
+			t = alias
+		}
+		if !_eventTypeValid(t) {
+			return nil, cli.NewExitError(fmt.Sprintf("unknown event type '%s' (%s)", t, strings.Join(eventTypes, ", ")), ExitCodeInvalidArgs)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// _eventProjectMatch Return false for an event of another project than the
+// watched one (events not related to a project always match)
+func _eventProjectMatch(evType, id, prjID string) bool {
+	return prjID == "" || id == "" || !strings.HasPrefix(evType, "project-") || id == prjID
+}
+
+func _eventTypeValid(t string) bool {
+	for _, et := range eventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// _eventDecode Decode data of an event and return it with the ID of the
+// object it relates to and a human readable description
+func _eventDecode(ev xaapiv1.EventMsg) (interface{}, string, string) {
+	switch ev.Type {
+	case xaapiv1.EVTServerConfig:
+		svr, err := ev.DecodeServerCfg()
+		if err != nil {
+			break
+		}
+		return svr, svr.ID, fmt.Sprintf("server %s url=%s connected=%v disabled=%v", svr.ID, svr.URL, svr.Connected, svr.Disabled)
+
+	case xaapiv1.EVTProjectAdd, xaapiv1.EVTProjectDelete, xaapiv1.EVTProjectChange:
+		prj, err := ev.DecodeProjectConfig()
+		if err != nil {
+			break
+		}
+		return prj, prj.ID, fmt.Sprintf("project %s (%s) status=%s inSync=%v", prj.ID, prj.Label, prj.Status, prj.IsInSync)
+
+	case xaapiv1.EVTSDKInstall, xaapiv1.EVTSDKRemove:
+		msg, err := ev.DecodeSDKMsg()
+		if err != nil {
+			break
+		}
+		line := fmt.Sprintf("sdk %s (%s) action=%s progress=%d%%", msg.Sdk.ID, msg.Sdk.Name, msg.Action, msg.Progress)
+		if out := strings.TrimSpace(msg.Stdout + msg.Stderr); out != "" {
+			lines := strings.Split(out, "\n")
+			line += " output=" + fmt.Sprintf("%q", lines[len(lines)-1])
+		}
+		if msg.Exited {
+			line += fmt.Sprintf(" exited code=%d", msg.Code)
+			if msg.Error != "" {
+				line += fmt.Sprintf(" error=%q", msg.Error)
+			}
+		}
+		return msg, msg.Sdk.ID, line
+	}
+
+	// Unknown or undecodable event: raw data
+	b, _ := json.Marshal(ev.Data)
+	return ev.Data, "", string(b)
+}
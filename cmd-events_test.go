@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestEventTypesParse(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"", eventTypes, false},
+		{"sdk-install", []string{xaapiv1.EVTSDKInstall}, false},
+		{"project-add, project-change", []string{xaapiv1.EVTProjectAdd, xaapiv1.EVTProjectChange}, false},
+		{xaapiv1.EVTProjectChange, []string{xaapiv1.EVTProjectChange}, false},
+		{"sdk-install,unknown", nil, true},
+		{"all", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := _eventTypesParse(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("_eventTypesParse(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("_eventTypesParse(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestEventProjectFilter(t *testing.T) {
+	prjEvent := func(evType, id string) xaapiv1.EventMsg {
+		return xaapiv1.EventMsg{Type: evType, Data: map[string]interface{}{"id": id, "label": "app"}}
+	}
+	tests := []struct {
+		name  string
+		ev    xaapiv1.EventMsg
+		prjID string
+		want  bool
+	}{
+		{"no project filter", prjEvent(xaapiv1.EVTProjectChange, "p2"), "", true},
+		{"watched project", prjEvent(xaapiv1.EVTProjectChange, "p1"), "p1", true},
+		{"other project change", prjEvent(xaapiv1.EVTProjectChange, "p2"), "p1", false},
+		{"other project add", prjEvent(xaapiv1.EVTProjectAdd, "p2"), "p1", false},
+		{"other project delete", prjEvent(xaapiv1.EVTProjectDelete, "p2"), "p1", false},
+		{"sdk event", xaapiv1.EventMsg{Type: xaapiv1.EVTSDKInstall,
+			Data: map[string]interface{}{"sdk": map[string]interface{}{"id": "s1"}}}, "p1", true},
+		{"server event", xaapiv1.EventMsg{Type: xaapiv1.EVTServerConfig,
+			Data: map[string]interface{}{"id": "srv1"}}, "p1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, id, _ := _eventDecode(tt.ev)
+			if got := _eventProjectMatch(tt.ev.Type, id, tt.prjID); got != tt.want {
+				t.Errorf("_eventProjectMatch(%s, %q, %q) = %v, want %v", tt.ev.Type, id, tt.prjID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		ev       xaapiv1.EventMsg
+		wantID   string
+		wantLine string
+	}{
+		{"project", xaapiv1.EventMsg{Type: xaapiv1.EVTProjectChange,
+			Data: map[string]interface{}{"id": "p1", "label": "app", "status": "Enable", "isInSync": true}},
+			"p1", "project p1 (app) status=Enable inSync=true"},
+		{"sdk install output", xaapiv1.EventMsg{Type: xaapiv1.EVTSDKInstall,
+			Data: map[string]interface{}{"sdk": map[string]interface{}{"id": "s1", "name": "poky"},
+				"action": "installing", "progress": 50, "stdout": "step 1\nstep 2\n"}},
+			"s1", `sdk s1 (poky) action=installing progress=50% output="step 2"`},
+		{"sdk install exit", xaapiv1.EventMsg{Type: xaapiv1.EVTSDKInstall,
+			Data: map[string]interface{}{"sdk": map[string]interface{}{"id": "s1", "name": "poky"},
+				"action": "installing", "progress": 100, "exited": true, "code": 1, "error": "failed"}},
+			"s1", `sdk s1 (poky) action=installing progress=100% exited code=1 error="failed"`},
+		{"unknown type", xaapiv1.EventMsg{Type: "other", Data: map[string]interface{}{"id": "x"}},
+			"", `{"id":"x"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, id, line := _eventDecode(tt.ev)
+			if id != tt.wantID || line != tt.wantLine {
+				t.Errorf("_eventDecode() = %q, %q, want %q, %q", id, line, tt.wantID, tt.wantLine)
+			}
+		})
+	}
+}
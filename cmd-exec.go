@@ -19,11 +19,21 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
+	osexec "os/exec"
+	"os/signal"
+	"path"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/joho/godotenv"
 	"github.com/urfave/cli"
 )
 
@@ -32,38 +42,495 @@ func initCmdExec(cmdDef *[]cli.Command) {
 		Name:   "exec",
 		Usage:  "execute a command in XDS",
 		Action: exec,
-		Flags: []cli.Flag{
-			cli.StringFlag{
-				Name:   "id",
-				EnvVar: "XDS_PROJECT_ID",
-				Usage:  "project ID you want to build (mandatory variable)",
-			},
-			cli.StringFlag{
-				Name:   "rpath, p",
-				EnvVar: "XDS_RPATH",
-				Usage:  "relative path into project",
-			},
-			cli.StringFlag{
-				Name:   "sdkid, sdk",
-				EnvVar: "XDS_SDK_ID",
-				Usage:  "Cross Sdk ID to use to build project",
+		Description: `Environment of the remote command is built from the following sources,
+   each one overwriting variables set by the previous ones:
+     1. variables of config file (see --config option),
+     2. variables of files set by --env-file options (in the given order),
+     3. local environment variables matching a --env-pass pattern,
+     4. variables set by --env options,
+   and finally variables matching an --env-unset pattern are removed.
+   Variables are always sent sorted by name.
+
+   Executed commands are recorded in a local history (see history command),
+   --last option executes again the last one.
+
+   When --sdkid is not set, project default SDK is used, else the only installed
+   SDK if any (an error lists installed SDKs when there are several ones). --sdkid may also be a query matched against installed SDKs: comma
+   separated criteria on id, name, profile, version or arch fields using =, !=,
+   ~ (regexp) or >, >=, <, <= (version comparison) operators.
+
+   In watch mode (--watch), project local tree is watched and command is executed
+   again once files changed and project is back in sync; a still running command
+   is cancelled. Patterns without slash match any path element (e.g. 'build' or
+   '*.o'), others match a path relative to project local directory. Usual build
+   output (build directories, object files, libraries...) is ignored unless
+   --watch-no-build-excludes is set, other output of the command must be excluded
+   using --watch-exclude (otherwise each execution triggers a new one).`,
+		Flags: append(execFlags(),
+			cli.BoolFlag{
+				Name:  "last",
+				Usage: "execute again the last executed command (see history command)",
 			},
-		},
+		),
 	})
 }
 
+// execFlags returns options of exec command (also used by run command)
+func execFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "id",
+			EnvVar: "XDS_PROJECT_ID",
+			Usage:  "project ID (or ID prefix or label) you want to build (mandatory variable)",
+		},
+		cli.StringFlag{
+			Name:   "rpath, p",
+			EnvVar: "XDS_RPATH",
+			Usage:  "relative path into project",
+		},
+		cli.StringFlag{
+			Name:   "sdkid, sdk",
+			EnvVar: "XDS_SDK_ID",
+			Usage:  "Cross Sdk ID to use to build project, or a query (e.g. 'arch=aarch64,profile=agl-demo,version>=5.0')",
+		},
+		cli.BoolFlag{
+			Name:  "sync",
+			Usage: "request a synchronization of project sources before executing command",
+		},
+		cli.BoolFlag{
+			Name:   "no-sync-wait",
+			EnvVar: "XDS_EXEC_NO_SYNC_WAIT",
+			Usage:  "don't wait until project sources are in sync before executing command",
+		},
+		cli.StringFlag{
+			Name:   "sync-timeout",
+			EnvVar: "XDS_SYNC_TIMEOUT",
+			Value:  DefaultSyncTimeout,
+			Usage:  "maximum duration to wait for project sources synchronization (e.g. 30s or 5m), 0 means unlimited",
+		},
+		cli.StringSliceFlag{
+			Name:  "sdk-matrix",
+			Usage: "run command once per SDK of this list of IDs (comma separated or repeated option)",
+		},
+		cli.StringFlag{
+			Name:  "sdk-filter",
+			Usage: "run command once per installed SDK matching this regexp (matching done on ID, Name, Profile, Version and Arch fields)",
+		},
+		cli.IntFlag{
+			Name:  "matrix-jobs, j",
+			Usage: "maximum number of SDKs processed in parallel in SDK matrix mode (0 means no limit)",
+		},
+		cli.StringSliceFlag{
+			Name:  "env, e",
+			Usage: "set a variable of remote command env (KEY=VALUE, or KEY to use local value)",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read variables of remote command env from a file",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-pass",
+			Usage: "forward local variables matching a pattern (e.g. 'CI_*')",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-unset",
+			Usage: "remove variables matching a pattern from remote command env",
+		},
+		cli.StringFlag{
+			Name:   "timeout",
+			EnvVar: "XDS_EXEC_TIMEOUT",
+			Value:  "60s",
+			Usage:  "command completion timeout (e.g. 90, 45m or 2h), 0 means unlimited",
+		},
+		cli.StringFlag{
+			Name:   "inactivity-timeout",
+			EnvVar: "XDS_EXEC_INACTIVITY_TIMEOUT",
+			Usage:  "kill command when no output is produced during this duration (e.g. 10m)",
+		},
+		cli.BoolFlag{
+			Name:   "no-path-translation",
+			EnvVar: "XDS_EXEC_NO_PATH_TRANSLATION",
+			Usage:  "don't translate local paths into server paths in command arguments (pathmap projects)",
+		},
+		cli.BoolFlag{
+			Name:   "no-output-rewrite",
+			EnvVar: "XDS_EXEC_NO_OUTPUT_REWRITE",
+			Usage:  "don't rewrite server paths into local paths in command output (pathmap projects)",
+		},
+		cli.StringFlag{
+			Name:   "sdk-local-path",
+			EnvVar: "XDS_SDK_LOCAL_PATH",
+			Usage:  "local copy of the SDK, used to rewrite SDK sysroot paths in command output",
+		},
+		cli.BoolFlag{
+			Name:   "no-stdin",
+			EnvVar: "XDS_EXEC_NO_STDIN",
+			Usage:  "don't forward local standard input to the remote command",
+		},
+		cli.StringSliceFlag{
+			Name:  "fetch",
+			Usage: "download files matching this glob pattern (relative to rpath) once command succeeded",
+		},
+		cli.StringFlag{
+			Name:  "fetch-dir",
+			Usage: "local destination directory of fetched files (default project local path)",
+		},
+		cli.BoolFlag{
+			Name:  "diagnostics",
+			Usage: "parse compiler, linker, cmake and make diagnostics and display a summary at the end",
+		},
+		cli.StringFlag{
+			Name:  "diagnostics-out",
+			Usage: "write parsed diagnostics into this file (implies --diagnostics)",
+		},
+		cli.StringFlag{
+			Name:  "diagnostics-format",
+			Usage: "format of diagnostics file: sarif or json (default set from file extension)",
+		},
+		cli.BoolFlag{
+			Name:  "watch, w",
+			Usage: "execute command again each time files of project local tree change",
+		},
+		cli.StringSliceFlag{
+			Name:  "watch-include",
+			Usage: "in watch mode, only consider changes of files matching this glob pattern (e.g. '*.c' or 'src/**/*.h')",
+		},
+		cli.StringSliceFlag{
+			Name:  "watch-exclude",
+			Usage: "in watch mode, ignore files and directories matching this glob pattern (e.g. 'out')",
+		},
+		cli.BoolFlag{
+			Name:  "watch-no-build-excludes",
+			Usage: "in watch mode, don't ignore usual build output (" + strings.Join(WatchBuildExcludes, " ") + ")",
+		},
+		cli.StringFlag{
+			Name:   "watch-debounce",
+			EnvVar: "XDS_WATCH_DEBOUNCE",
+			Value:  "500ms",
+			Usage:  "in watch mode, delay without any change before executing command again",
+		},
+		cli.BoolFlag{
+			Name:   "watch-clear",
+			EnvVar: "XDS_WATCH_CLEAR",
+			Usage:  "in watch mode, clear terminal before each execution",
+		},
+		cli.StringFlag{
+			Name:  "provenance",
+			Usage: "write a build provenance manifest (in-toto / SLSA) into this file once command exited (sha256 digests of env variables values are recorded)",
+		},
+		cli.BoolFlag{
+			Name:  "provenance-env-clear",
+			Usage: "record values of env variables in provenance manifest instead of their digest (beware of secrets)",
+		},
+		cli.BoolFlag{
+			Name:  "detach, d",
+			Usage: "run command in background and print its job ID (see jobs command)",
+		},
+		cli.BoolFlag{
+			Name:   "job-worker",
+			EnvVar: "XDS_EXEC_JOB_WORKER",
+			Hidden: true,
+		},
+	}
+}
+
+// execStdinEOF notifies end of local stdin: XDS server closes stdin of the
+// remote command when it receives a single Ctrl-D (EOT) character
+const execStdinEOF = "\x04"
+
+// execStdinRead starts reading local standard input and returns read chunks
+// (channel is closed at end of file); reading is started before the command
+// so that input available before its start is buffered and not lost
+func execStdinRead(in io.Reader) <-chan string {
+	chunks := make(chan string, 256)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 4096)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				chunks <- string(buf[:n])
+			}
+			if err != nil {
+				if err != io.EOF {
+					Log.Errorf("Error while reading stdin: %v", err)
+				}
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+// execStdinForward forwards chunks of local stdin to the remote command over
+// the socket.io connection, followed by an EOF notification
+func execStdinForward(chunks <-chan string) {
+	for data := range chunks {
+		if err := IOsk.Emit(xaapiv1.ExecInEvent, data); err != nil {
+			Log.Errorf("Error while forwarding stdin: %v", err)
+			return
+		}
+	}
+	Log.Debugf("Stdin EOF, notify remote command")
+	if err := IOsk.Emit(xaapiv1.ExecInEvent, execStdinEOF); err != nil {
+		Log.Errorf("Error while forwarding stdin EOF: %v", err)
+	}
+}
+
+// execSignalNames maps trapped local signals to names used by XDS signal API
+var execSignalNames = map[os.Signal]string{
+	os.Interrupt:    "SIGINT",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGQUIT: "SIGQUIT",
+}
+
+// execSignalSend sends a signal to a remote command
+func execSignalSend(cmdID, sigName string) error {
+	args := xaapiv1.ExecSignalArgs{CmdID: cmdID, Signal: sigName}
+	LogPost("POST /signal %v", args)
+	res := xaapiv1.ExecSigResult{}
+	return HTTPCli.Post("/signal", args, &res)
+}
+
+// execIsAlive returns true when a remote command is still running (a harmless
+// SIGCONT signal is accepted only by a known running command)
+func execIsAlive(cmdID string) bool {
+	err := execSignalSend(cmdID, "SIGCONT")
+	Log.Debugf("Command %s alive check: %v", cmdID, err)
+	return err == nil
+}
+
+// execInterrupted is set once a signal has been received
+var execInterrupted int32
+
+// execSignalForward forwards local signals to running remote commands: first
+// one is forwarded as is and any next one kills remote commands
+func execSignalForward(sigs chan os.Signal) {
+	nbSig := 0
+	for sig := range sigs {
+		nbSig++
+		atomic.StoreInt32(&execInterrupted, 1)
+		sigName := execSignalNames[sig]
+		if nbSig > 1 {
+			sigName = "SIGKILL"
+		}
+		for _, r := range execRunnersList() {
+			Log.Debugf("Signal %v received, send %s to command %s", sig, sigName, r.CmdID)
+			if err := execSignalSend(r.CmdID, sigName); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR while sending %s to remote command: %v\n", sigName, err)
+			}
+		}
+		if nbSig == 1 {
+			fmt.Fprintf(os.Stderr, "\n%s sent to remote command, waiting for its termination (press Ctrl+C again to kill it)\n", sigName)
+		} else {
+			fmt.Fprintf(os.Stderr, "\n%s sent to remote command\n", sigName)
+		}
+	}
+}
+
+// execEnvBuild returns the sorted environment of the remote command
+// (see exec command description for precedence order)
+func execEnvBuild(ctx *cli.Context) ([]string, error) {
+	envMap := make(map[string]string)
+	for k, v := range EnvConfFileMap {
+		envMap[k] = v
+	}
+
+	for _, file := range ctx.StringSlice("env-file") {
+		vars, err := godotenv.Read(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading env file %s: %v", file, err)
+		}
+		for k, v := range vars {
+			envMap[k] = v
+		}
+	}
+
+	for _, pattern := range ctx.StringSlice("env-pass") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --env-pass pattern '%s'", pattern)
+		}
+		for _, kv := range os.Environ() {
+			k, v := kv, ""
+			if idx := strings.Index(kv, "="); idx >= 0 {
+				k, v = kv[:idx], kv[idx+1:]
+			}
+			if m, _ := path.Match(pattern, k); m {
+				envMap[k] = v
+			}
+		}
+	}
+
+	for _, kv := range ctx.StringSlice("env") {
+		idx := strings.Index(kv, "=")
+		if idx == 0 {
+			return nil, fmt.Errorf("invalid --env value '%s' (must be KEY=VALUE)", kv)
+		}
+		if idx < 0 {
+			envMap[kv] = os.Getenv(kv)
+		} else {
+			envMap[kv[:idx]] = kv[idx+1:]
+		}
+	}
+
+	for _, pattern := range ctx.StringSlice("env-unset") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --env-unset pattern '%s'", pattern)
+		}
+		for k := range envMap {
+			if m, _ := path.Match(pattern, k); m {
+				delete(envMap, k)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(envMap))
+	for k := range envMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+envMap[k])
+	}
+	return env, nil
+}
+
+// execOutputMappings returns server to local path mappings used to rewrite
+// command output
+func execOutputMappings(ctx *cli.Context, prj xaapiv1.ProjectConfig, sdkid string) []PathMapping {
+	mappings := []PathMapping{}
+	if prj.Type == xaapiv1.TypePathMap {
+		mappings = append(mappings, PathMapping{From: prj.ServerPath, To: prj.ClientPath})
+	}
+
+	sdkLocalPath := ctx.String("sdk-local-path")
+	if sdkLocalPath != "" && sdkid != "" {
+		sdk := xaapiv1.SDK{}
+		if err := HTTPCli.Get(XdsServerComputeURL("/sdks/"+sdkid), &sdk); err != nil {
+			Log.Warningf("Cannot retrieve SDK %s, SDK paths won't be rewritten: %v", sdkid, err)
+		} else if sdk.Path != "" {
+			mappings = append(mappings, PathMapping{From: sdk.Path, To: sdkLocalPath})
+		}
+	}
+	return mappings
+}
+
+// execDetach re-executes the current command line in a background worker
+// process and returns as soon as the worker reports the started job ID
+func execDetach() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+
+	// Worker stdout and stderr are only used to report job ID or errors
+	r, w, err := os.Pipe()
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	defer r.Close()
+
+	cmd := osexec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), "XDS_EXEC_JOB_WORKER=1")
+	cmd.Stdout = w
+	cmd.Stderr = w
+	// New session: worker must not be killed with the terminal or the shell
+	cmd.SysProcAttr = procDetachAttr()
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return cli.NewExitError("Cannot start background job: "+err.Error(), ExitCodeError)
+	}
+
+	errMsg := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "JOB ") {
+			jobID := strings.TrimPrefix(line, "JOB ")
+			cmd.Process.Release()
+			fmt.Println(jobID)
+			fmt.Fprintf(os.Stderr, "Job started in background, use '%s jobs attach %s' to follow it.\n", AppName, jobID)
+			return nil
+		}
+		errMsg += line + "\n"
+	}
+
+	cmd.Wait()
+	return cli.NewExitError("Cannot start background job: "+strings.TrimSpace(errMsg), ExitCodeError)
+}
+
 func exec(ctx *cli.Context) error {
+	if ctx.Bool("last") {
+		if ctx.NArg() > 0 {
+			return cli.NewExitError("no command must be set with --last option", ExitCodeInvalidArgs)
+		}
+		h, err := HistoryGet("")
+		if err != nil {
+			return ExitErrorFrom(err, ExitCodeError)
+		}
+		return HistoryRerun(ctx, h)
+	}
+	return execRun(ctx, ctx.Args())
+}
+
+// execRun executes a command (set in argsCommand) using options of ctx
+func execRun(ctx *cli.Context, argsCommand []string) error {
 	prjID := ctx.String("id")
 	rPath := ctx.String("rpath")
 	sdkid := ctx.String("sdkid")
 
 	// Check mandatory args
 	if prjID == "" {
-		return cli.NewExitError("project id must be set (see --id option)", 1)
+		return cli.NewExitError("project id must be set (see --id option)", ExitCodeInvalidArgs)
+	}
+	if len(argsCommand) == 0 {
+		return cli.NewExitError("command to execute must be set", ExitCodeInvalidArgs)
 	}
 
-	argsCommand := make([]string, len(ctx.Args()))
-	copy(argsCommand, ctx.Args())
+	timeout, err := ParseTimeout(ctx.String("timeout"))
+	if err != nil {
+		return cli.NewExitError("--timeout: "+err.Error(), ExitCodeInvalidArgs)
+	}
+	inactivityTimeout, err := ParseTimeout(ctx.String("inactivity-timeout"))
+	if err != nil {
+		return cli.NewExitError("--inactivity-timeout: "+err.Error(), ExitCodeInvalidArgs)
+	}
+	syncTimeout, err := ParseTimeout(ctx.String("sync-timeout"))
+	if err != nil {
+		return cli.NewExitError("--sync-timeout: "+err.Error(), ExitCodeInvalidArgs)
+	}
+	reconnectTimeout, err := ParseTimeout(ctx.GlobalString("reconnect-timeout"))
+	if err != nil {
+		return cli.NewExitError("--reconnect-timeout: "+err.Error(), ExitCodeInvalidArgs)
+	}
+
+	jobWorker := ctx.Bool("job-worker")
+	matrix := len(ctx.StringSlice("sdk-matrix")) > 0 || ctx.String("sdk-filter") != ""
+	fetchPatterns := ctx.StringSlice("fetch")
+	watch := ctx.Bool("watch")
+	provenanceFile := ctx.String("provenance")
+	if provenanceFile != "" && (matrix || watch) {
+		return cli.NewExitError("--provenance option cannot be used with a SDK matrix or --watch", ExitCodeInvalidArgs)
+	}
+	if watch && (matrix || ctx.Bool("detach") || len(fetchPatterns) > 0) {
+		return cli.NewExitError("--watch option cannot be used with a SDK matrix, --detach or --fetch", ExitCodeInvalidArgs)
+	}
+	if len(fetchPatterns) > 0 && (matrix || ctx.Bool("detach")) {
+		return cli.NewExitError("--fetch option cannot be used with a SDK matrix or --detach", ExitCodeInvalidArgs)
+	}
+	if ctx.Bool("detach") && !jobWorker {
+		if matrix {
+			return cli.NewExitError("--detach option cannot be used with a SDK matrix", ExitCodeInvalidArgs)
+		}
+		return execDetach()
+	}
+
+	argsCommand = append([]string{}, argsCommand...)
+	origArgs := append([]string{}, argsCommand...) // before paths translation
+	cmdLine := strings.TrimSpace(strings.Join(origArgs, " "))
 	Log.Infof("Execute: /exec %v", argsCommand)
 
 	// Log useful info for debugging
@@ -71,52 +538,25 @@ func exec(ctx *cli.Context) error {
 	XdsVersionGet(&ver)
 	Log.Infof("XDS version: %v", ver)
 
-	// Process Socket IO events
-	type exitResult struct {
-		error error
-		code  int
+	// Output writers (all output goes into job log file for background jobs)
+	var outW, errW io.Writer = os.Stdout, os.Stderr
+	jobLog := &JobLogWriter{}
+	if jobWorker {
+		outW, errW = jobLog, jobLog
 	}
-	exitChan := make(chan exitResult, 1)
 
-	IOsk.On("disconnection", func(err error) {
-		Log.Debugf("WS disconnection event with err: %v\n", err)
-		exitChan <- exitResult{err, 2}
-	})
-
-	outFunc := func(timestamp, stdout, stderr string) {
-		tm := ""
-		if ctx.Bool("WithTimestamp") {
-			tm = timestamp + "| "
-		}
-		if stdout != "" {
-			fmt.Printf("%s%s", tm, stdout)
-		}
-		if stderr != "" {
-			fmt.Fprintf(os.Stderr, "%s%s", tm, stderr)
-		}
-	}
-
-	IOsk.On(xaapiv1.ExecOutEvent, func(ev xaapiv1.ExecOutMsg) {
-		outFunc(ev.Timestamp, ev.Stdout, ev.Stderr)
-	})
-
-	IOsk.On(xaapiv1.ExecExitEvent, func(ev xaapiv1.ExecExitMsg) {
-		exitChan <- exitResult{ev.Error, ev.Code}
-	})
-
-	IOsk.On(xaapiv1.EVTProjectChange, func(ev xaapiv1.EventMsg) {
-		prj, _ := ev.DecodeProjectConfig()
-		Log.Infof("Event %v (%v): %v", ev.Type, ev.Time, prj)
-	})
-	evReg := xaapiv1.EventRegisterArgs{Name: xaapiv1.EVTProjectChange}
-	if err := HTTPCli.Post("/events/register", &evReg, nil); err != nil {
-		return cli.NewExitError(err, 1)
+	// Process Socket IO events
+	if err := execEventsInit(reconnectTimeout, errW); err != nil {
+		return ExitErrorHTTP(err)
 	}
 
 	// Retrieve the project definition
+	if prjID, err = ProjectIDResolve(prjID); err != nil {
+		return ExitErrorHTTP(err)
+	}
 	prj := xaapiv1.ProjectConfig{}
 	if err := HTTPCli.Get("/projects/"+prjID, &prj); err != nil {
-		return cli.NewExitError(err, 1)
+		return ExitErrorHTTP(err)
 	}
 
 	// Auto setup rPath if needed
@@ -135,14 +575,34 @@ func exec(ctx *cli.Context) error {
 		}
 	}
 
+	// Select SDK (either set by ID or by a query, or project default SDK)
+	if !matrix {
+		sdk, err := SdkSelect(sdkid, prj)
+		if err != nil {
+			return ExitErrorHTTP(err)
+		}
+		sdkid = sdk.ID
+	}
+
+	// Translate local paths into server paths in command arguments
+	if !ctx.Bool("no-path-translation") && prj.Type == xaapiv1.TypePathMap {
+		argsCommand = PathTranslateArgs(argsCommand, []PathMapping{
+			{From: prj.ClientPath, To: prj.ServerPath},
+		})
+	}
+
 	// Build env
-	Log.Debugf("Command env: %v", EnvConfFileMap)
-	env := []string{}
-	for k, v := range EnvConfFileMap {
-		env = append(env, k+"="+v)
+	env, err := execEnvBuild(ctx)
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
 	}
+	Log.Debugf("Command env: %v", env)
 
-	// Send build command
+	// Send build command (a negative timeout means no timeout)
+	cmdTimeout := -1
+	if timeout > 0 {
+		cmdTimeout = int((timeout + time.Second - 1) / time.Second)
+	}
 	args := xaapiv1.ExecArgs{
 		ID:         prjID,
 		SdkID:      sdkid,
@@ -150,25 +610,235 @@ func exec(ctx *cli.Context) error {
 		Args:       argsCommand[1:],
 		Env:        env,
 		RPath:      rPath,
-		CmdTimeout: 60,
+		CmdTimeout: cmdTimeout,
 	}
 
-	LogPost("POST /exec %v", args)
-	if err := HTTPCli.Post("/exec", args, nil); err != nil {
-		return cli.NewExitError(err.Error(), 1)
+	// Make sure that command will be executed on up-to-date sources
+	if ctx.Bool("sync") {
+		if err := ProjectSyncRequest(prjID); err != nil {
+			return ExitErrorHTTP(err)
+		}
+	}
+	if !ctx.Bool("no-sync-wait") {
+		if err := ProjectSyncWait(prjID, syncTimeout, ctx.Bool("sync")); err != nil {
+			return ExitErrorHTTP(err)
+		}
+	}
+
+	// Trap signals before starting command to be sure to never leave it running
+	// on server side; signals are forwarded as soon as command ID is known
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+
+	if watch {
+		return execWatch(ctx, prj, args, sigs, inactivityTimeout, syncTimeout)
+	}
+	if matrix {
+		return execMatrix(ctx, prj, args, sigs, inactivityTimeout)
+	}
+
+	diags := execDiagCollectorNew(ctx, prj)
+	r := execRunnerNew(ctx, prj, args, outW, errW)
+	r.Diags = diags
+	if eta, nb := HistoryETA(prjID, sdkid, rPath, cmdLine); nb > 0 && !jobWorker && IsTerminal(os.Stderr) {
+		fmt.Fprintf(os.Stderr, "ETA %s (~%v, based on %d previous run(s))\n",
+			time.Now().Add(eta).Format("15:04:05"), DurationRound(eta, time.Second), nb)
+	}
+	// Read local stdin (either a pipe, a file or a keyboard) before starting
+	// command, it is forwarded once command is started
+	var stdinChunks <-chan string
+	if !ctx.Bool("no-stdin") && !jobWorker {
+		stdinChunks = execStdinRead(os.Stdin)
+	}
+	// Sources state is the one used by command (and not the one once it exited)
+	var git *ProvenanceGit
+	if provenanceFile != "" {
+		git = ProvenanceGitGet(prj.ClientPath)
+	}
+	startTime := time.Now()
+	if err := r.Start(); err != nil {
+		return ExitErrorHTTP(err)
+	}
+
+	go execSignalForward(sigs)
+
+	// Background job: record job, report its ID to parent process and then
+	// stop using stdout/stderr that parent process is about to close
+	var job JobInfo
+	if jobWorker {
+		job = JobInfo{
+			ID:        r.CmdID,
+			PID:       os.Getpid(),
+			ProjectID: prjID,
+			SdkID:     sdkid,
+			Cmd:       args.Cmd,
+			Args:      args.Args,
+			RPath:     rPath,
+			Status:    JobStatusRunning,
+			StartTime: time.Now(),
+		}
+		if err := _jobCreate(job, jobLog); err != nil {
+			execSignalSend(r.CmdID, "SIGKILL")
+			return cli.NewExitError("Cannot create job: "+err.Error(), ExitCodeError)
+		}
+		signal.Ignore(syscall.SIGHUP)
+		fmt.Printf("JOB %s\n", job.ID)
+		// Errors and logs are kept into job log file
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout, os.Stderr = devNull, devNull
+		}
+		cli.ErrWriter, Log.Out = jobLog, jobLog
+	}
+
+	if stdinChunks != nil {
+		go execStdinForward(stdinChunks)
 	}
 
 	// Wait exit
-	select {
-	case res := <-exitChan:
-		errStr := ""
-		if res.code == 0 {
-			Log.Debugln("Exit successfully")
+	res := r.Wait(inactivityTimeout)
+	errStr := ""
+	if res.Code == 0 {
+		Log.Debugln("Exit successfully")
+	}
+	if res.Error != nil {
+		Log.Debugln("Exit with ERROR: ", res.Error.Error())
+		errStr = res.Error.Error()
+	}
+	exitErr := execExitError(res)
+	endTime := time.Now()
+	hist := HistoryEntry{
+		Time:      startTime,
+		ProjectID: prjID,
+		SdkID:     sdkid,
+		Cmd:       origArgs[0],
+		Args:      origArgs[1:],
+		RPath:     rPath,
+		Duration:  endTime.Sub(startTime).Seconds(),
+		ExitCode:  res.Code,
+	}
+	if err := HistoryAdd(hist); err != nil {
+		Log.Warningf("Cannot record command in history: %v", err)
+	}
+	if err := execDiagReport(ctx, diags, errW); err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	fetched := []FetchedFile{}
+	if res.Code == 0 && len(fetchPatterns) > 0 {
+		fetched, err = ProjectFetch(FetchArgs{
+			Project:  prj,
+			RPath:    rPath,
+			Patterns: fetchPatterns,
+			DestDir:  ctx.String("fetch-dir"),
+		})
+		if err != nil {
+			// Remote command succeeded, only fetch of its files failed
+			return ExitErrorFrom(err, ExitCodeError)
+		}
+	}
+	if provenanceFile != "" {
+		sdk := xaapiv1.SDK{ID: sdkid}
+		if sdkid != "" {
+			if err := HTTPCli.Get(XdsServerComputeURL("/sdks/"+sdkid), &sdk); err != nil {
+				Log.Warningf("Cannot retrieve SDK %s: %v", sdkid, err)
+			}
 		}
-		if res.error != nil {
-			Log.Debugln("Exit with ERROR: ", res.error.Error())
-			errStr = res.error.Error()
+		err := ProvenanceWrite(provenanceFile, ProvenanceInfo{
+			Version:  ver,
+			Project:  prj,
+			Sdk:      sdk,
+			Exec:     args,
+			Git:      git,
+			EnvClear: ctx.Bool("provenance-env-clear"),
+			Start:    startTime,
+			End:      endTime,
+			ExitCode: res.Code,
+			Subjects: fetched,
+		})
+		if err != nil {
+			return cli.NewExitError("Cannot write provenance manifest: "+err.Error(), ExitCodeError)
+		}
+		Log.Infof("Provenance manifest written into %s", provenanceFile)
+	}
+	if jobWorker {
+		job.Status = JobStatusExited
+		job.ExitCode = res.Code
+		job.Error = errStr
+		job.EndTime = time.Now()
+		if err := _jobInfoWrite(job); err != nil {
+			return cli.NewExitError("Cannot update job: "+err.Error(), ExitCodeError)
 		}
-		return cli.NewExitError(errStr, res.code)
 	}
+	return exitErr
+}
+
+// execExitError returns the exit error of a command: either the error of a
+// client side failure or the remote command exit code
+func execExitError(res ExecExitResult) error {
+	if _, ok := res.Error.(cli.ExitCoder); ok {
+		return res.Error
+	}
+	errStr := ""
+	if res.Error != nil {
+		errStr = res.Error.Error()
+	}
+	if res.Code != 0 && atomic.LoadInt32(&execInterrupted) != 0 {
+		if errStr == "" {
+			errStr = "interrupted"
+		}
+		return cli.NewExitError(errStr, ExitCodeInterrupted)
+	}
+	return NewRemoteExitError(errStr, res.Code)
+}
+
+// execDiagCollectorNew returns a diagnostics collector when requested by options
+func execDiagCollectorNew(ctx *cli.Context, prj xaapiv1.ProjectConfig) *DiagCollector {
+	if !ctx.Bool("diagnostics") && ctx.String("diagnostics-out") == "" {
+		return nil
+	}
+	mappings := []PathMapping{}
+	if prj.ServerPath != "" {
+		mappings = append(mappings, PathMapping{From: prj.ServerPath, To: prj.ClientPath})
+	}
+	return NewDiagCollector(prj.ClientPath, ctx.String("rpath"), mappings)
+}
+
+// execDiagReport displays diagnostics summary and writes diagnostics file
+func execDiagReport(ctx *cli.Context, diags *DiagCollector, w io.Writer) error {
+	if diags == nil {
+		return nil
+	}
+	diags.Close()
+	diags.PrintSummary(w, 5)
+
+	file := ctx.String("diagnostics-out")
+	if file == "" {
+		return nil
+	}
+	format := strings.ToLower(ctx.String("diagnostics-format"))
+	if format == "" {
+		format = "json"
+		if strings.HasSuffix(file, ".sarif") || strings.HasSuffix(file, ".sarif.json") {
+			format = "sarif"
+		}
+	}
+	if err := diags.WriteReport(file, format); err != nil {
+		return fmt.Errorf("cannot write diagnostics file: %v", err)
+	}
+	Log.Infof("Diagnostics written into %s", file)
+	return nil
+}
+
+// execRunnerNew creates a runner of a command, setting up rewriting of server
+// paths into local paths in command output
+func execRunnerNew(ctx *cli.Context, prj xaapiv1.ProjectConfig, args xaapiv1.ExecArgs, outW, errW io.Writer) *ExecRunner {
+	r := NewExecRunner(args, outW, errW)
+	r.WithTimestamp = ctx.Bool("WithTimestamp")
+	if !ctx.Bool("no-output-rewrite") {
+		mappings := execOutputMappings(ctx, prj, args.SdkID)
+		Log.Debugf("Output path mappings: %v", mappings)
+		r.StdoutRw = NewPathRewriter(mappings)
+		r.StderrRw = NewPathRewriter(mappings)
+	}
+	return r
 }
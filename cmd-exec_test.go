@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// execTestContext Return a context of exec command with args parsed
+func execTestContext(t *testing.T, args ...string) *cli.Context {
+	cmds := []cli.Command{}
+	initCmdExec(&cmds)
+	set := flag.NewFlagSet("exec", flag.ContinueOnError)
+	for _, f := range cmds[0].Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatalf("cannot parse %v: %v", args, err)
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestExecEnvBuild(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	envFile := filepath.Join(dir, "build.env")
+	if err := ioutil.WriteFile(envFile, []byte("FROM_FILE=file\nCONF=from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer testSetenv("XDSTEST_A", "local-a")()
+	defer testSetenv("XDSTEST_B", "local-b")()
+	defer testSetenv("XDSTEST_LOCAL", "local")()
+
+	savedConf := EnvConfFileMap
+	defer func() { EnvConfFileMap = savedConf }()
+	EnvConfFileMap = map[string]string{"CONF": "from-conf", "KEEP": "conf"}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{"config only", nil, []string{"CONF=from-conf", "KEEP=conf"}, false},
+		{"env file overrides config", []string{"--env-file", envFile},
+			[]string{"CONF=from-file", "FROM_FILE=file", "KEEP=conf"}, false},
+		{"env pass pattern", []string{"--env-pass", "XDSTEST_[AB]"},
+			[]string{"CONF=from-conf", "KEEP=conf", "XDSTEST_A=local-a", "XDSTEST_B=local-b"}, false},
+		{"env overrides all", []string{"--env-file", envFile, "--env-pass", "XDSTEST_A", "--env", "XDSTEST_A=set", "--env", "CONF=set"},
+			[]string{"CONF=set", "FROM_FILE=file", "KEEP=conf", "XDSTEST_A=set"}, false},
+		{"env with local value", []string{"--env", "XDSTEST_LOCAL", "--env", "EMPTY="},
+			[]string{"CONF=from-conf", "EMPTY=", "KEEP=conf", "XDSTEST_LOCAL=local"}, false},
+		{"env with equal in value", []string{"--env", "OPTS=-DX=1"},
+			[]string{"CONF=from-conf", "KEEP=conf", "OPTS=-DX=1"}, false},
+		{"env unset applies last", []string{"--env", "KEEP_ME=1", "--env-unset", "KEEP*", "--env-unset", "CONF"},
+			nil, false},
+		{"invalid env", []string{"--env", "=x"}, nil, true},
+		{"invalid env pass pattern", []string{"--env-pass", "["}, nil, true},
+		{"invalid env unset pattern", []string{"--env-unset", "["}, nil, true},
+		{"missing env file", []string{"--env-file", envFile + ".missing"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := execEnvBuild(execTestContext(t, tt.args...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("execEnvBuild() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want == nil {
+				tt.want = []string{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("execEnvBuild() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// HistoryMaxEntries Maximum number of entries kept in history
+const HistoryMaxEntries = 1000
+
+// HistoryEntry One command executed by exec command
+type HistoryEntry struct {
+	Num       int       `json:"num"`
+	Time      time.Time `json:"time"`
+	ProjectID string    `json:"projectID"`
+	SdkID     string    `json:"sdkID"`
+	Cmd       string    `json:"cmd"`
+	Args      []string  `json:"args"`
+	RPath     string    `json:"rpath"`
+	Duration  float64   `json:"duration"` // in seconds
+	ExitCode  int       `json:"exitCode"`
+}
+
+// CmdLine Return command line of a history entry
+func (h HistoryEntry) CmdLine() string {
+	return strings.TrimSpace(h.Cmd + " " + strings.Join(h.Args, " "))
+}
+
+func initCmdHistory(cmdDef *[]cli.Command) {
+	*cmdDef = append(*cmdDef, cli.Command{
+		Name:     "history",
+		HideHelp: true,
+		Usage:    "history of executed commands (see exec command)",
+		Subcommands: []cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "List executed commands",
+				Action:  historyList,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "search, s",
+						Usage: "regexp to filter output (filtering done on command line, project ID and sdk ID)",
+					},
+					cli.IntFlag{
+						Name:  "number, n",
+						Usage: "number of displayed entries (0 means all)",
+						Value: 20,
+					},
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Show details of an history entry (default last one)",
+				ArgsUsage: "[entry number]",
+				Action:    historyShow,
+			},
+			{
+				Name:      "rerun",
+				Usage:     "Execute again the command of an history entry (default last one)",
+				ArgsUsage: "[entry number]",
+				Action:    historyRerunAction,
+				Flags:     execFlags(),
+			},
+			{
+				Name:   "clear",
+				Usage:  "Remove all history entries",
+				Action: historyClear,
+			},
+		},
+	})
+}
+
+func historyList(ctx *cli.Context) error {
+	entries, err := HistoryLoad()
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+
+	var re *regexp.Regexp
+	if search := ctx.String("search"); search != "" {
+		if re, err = regexp.Compile(search); err != nil {
+			return cli.NewExitError("invalid --search regexp: "+err.Error(), ExitCodeInvalidArgs)
+		}
+	}
+	found := []HistoryEntry{}
+	for _, h := range entries {
+		if re == nil || re.MatchString(h.CmdLine()) || re.MatchString(h.ProjectID) || re.MatchString(h.SdkID) {
+			found = append(found, h)
+		}
+	}
+	if n := ctx.Int("number"); n > 0 && len(found) > n {
+		found = found[len(found)-n:]
+	}
+
+	writer := NewTableWriter()
+	fmt.Fprintln(writer, "NUM\t DATE\t PROJECT\t EXIT CODE\t DURATION\t COMMAND")
+	for _, h := range found {
+		fmt.Fprintln(writer, h.Num, "\t", h.Time.Format("2006-01-02 15:04:05"), "\t", _shortID(h.ProjectID), "\t",
+			h.ExitCode, "\t", _historyDuration(h.Duration), "\t", h.CmdLine())
+	}
+	writer.Flush()
+	return nil
+}
+
+func historyShow(ctx *cli.Context) error {
+	h, err := HistoryGet(ctx.Args().First())
+	if err != nil {
+		return ExitErrorFrom(err, ExitCodeError)
+	}
+
+	writer := NewTableWriter()
+	fmt.Fprintln(writer, "Num:\t", h.Num)
+	fmt.Fprintln(writer, "Date:\t", h.Time.Format(time.RFC3339))
+	fmt.Fprintln(writer, "Project ID:\t", h.ProjectID)
+	fmt.Fprintln(writer, "Sdk ID:\t", h.SdkID)
+	fmt.Fprintln(writer, "Relative path:\t", h.RPath)
+	fmt.Fprintln(writer, "Command:\t", h.CmdLine())
+	fmt.Fprintln(writer, "Exit code:\t", h.ExitCode)
+	fmt.Fprintln(writer, "Duration:\t", _historyDuration(h.Duration))
+	writer.Flush()
+	return nil
+}
+
+func historyRerunAction(ctx *cli.Context) error {
+	h, err := HistoryGet(ctx.Args().First())
+	if err != nil {
+		return ExitErrorFrom(err, ExitCodeError)
+	}
+	return HistoryRerun(ctx, h)
+}
+
+func historyClear(ctx *cli.Context) error {
+	file, err := _historyFile()
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	unlock, err := _historyLock(file)
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	defer unlock()
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	fmt.Println("History cleared.")
+	return nil
+}
+
+// HistoryRerun Execute again the command of an history entry; project, SDK
+// and rpath of entry are only used when not set by options
+func HistoryRerun(ctx *cli.Context, h HistoryEntry) error {
+	settings := map[string]string{
+		"id":    h.ProjectID,
+		"rpath": h.RPath,
+		"sdkid": h.SdkID,
+	}
+	for opt, val := range settings {
+		if val != "" && !ctx.IsSet(opt) {
+			if err := ctx.Set(opt, val); err != nil {
+				return cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Rerun #%d: %s\n", h.Num, h.CmdLine())
+	return execRun(ctx, append([]string{h.Cmd}, h.Args...))
+}
+
+// HistoryAdd Append a new entry to history (entry number is set by this
+// function); history is locked so that concurrent commands get distinct numbers
+func HistoryAdd(h HistoryEntry) error {
+	file, err := _historyFile()
+	if err != nil {
+		return err
+	}
+	unlock, err := _historyLock(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := _historyLoad(file)
+	if err != nil {
+		return err
+	}
+	h.Num = 1
+	if len(entries) > 0 {
+		h.Num = entries[len(entries)-1].Num + 1
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	// Rewrite history file when too big, else simply append entry
+	if len(entries) >= HistoryMaxEntries {
+		entries = append(entries[len(entries)-HistoryMaxEntries+1:], h)
+		content := []byte{}
+		for _, e := range entries {
+			line, _ := json.Marshal(e)
+			content = append(append(content, line...), '\n')
+		}
+		if err := ioutil.WriteFile(file+".tmp", content, 0600); err != nil {
+			return err
+		}
+		return os.Rename(file+".tmp", file)
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// HistoryLoad Return all history entries, oldest first
+func HistoryLoad() ([]HistoryEntry, error) {
+	file, err := _historyFile()
+	if err != nil {
+		return nil, err
+	}
+	return _historyLoad(file)
+}
+
+func _historyLoad(file string) ([]HistoryEntry, error) {
+	entries := []HistoryEntry{}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		h := HistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+			Log.Debugf("Skip invalid history entry: %v", err)
+			continue
+		}
+		entries = append(entries, h)
+	}
+	return entries, scanner.Err()
+}
+
+// HistoryGet Return an history entry from its number (last entry when num is empty)
+func HistoryGet(num string) (HistoryEntry, error) {
+	entries, err := HistoryLoad()
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	if len(entries) == 0 {
+		return HistoryEntry{}, cli.NewExitError("history is empty", ExitCodeNotFound)
+	}
+	if num == "" {
+		return entries[len(entries)-1], nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(num, "#"))
+	if err != nil {
+		return HistoryEntry{}, cli.NewExitError("invalid history entry number '"+num+"'", ExitCodeInvalidArgs)
+	}
+	for _, h := range entries {
+		if h.Num == n {
+			return h, nil
+		}
+	}
+	return HistoryEntry{}, cli.NewExitError(fmt.Sprintf("unknown history entry %d", n), ExitCodeNotFound)
+}
+
+// HistoryETA Return estimated duration of a command, computed from durations
+// of last successful executions of the same command, and number of executions
+// used for this estimation
+func HistoryETA(prjID, sdkID, rPath, cmdLine string) (time.Duration, int) {
+	entries, err := HistoryLoad()
+	if err != nil {
+		return 0, 0
+	}
+	total, nb := 0.0, 0
+	for i := len(entries) - 1; i >= 0 && nb < 5; i-- {
+		h := entries[i]
+		if h.ExitCode == 0 && h.ProjectID == prjID && h.SdkID == sdkID && h.RPath == rPath && h.CmdLine() == cmdLine {
+			total += h.Duration
+			nb++
+		}
+	}
+	if nb == 0 {
+		return 0, 0
+	}
+	return time.Duration(total / float64(nb) * float64(time.Second)), nb
+}
+
+func _historyFile() (string, error) {
+	dir, err := StateDirGet()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// _historyLock Lock history using a lock file (history file itself may be
+// replaced) and return the function that releases lock
+func _historyLock(file string) (func(), error) {
+	f, err := os.OpenFile(file+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileLock(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot lock history: %v", err)
+	}
+	return func() {
+		fileUnlock(f)
+		f.Close()
+	}, nil
+}
+
+func _historyDuration(sec float64) string {
+	return (time.Duration(sec*float64(time.Second)) / time.Millisecond * time.Millisecond).String()
+}
+
+func _shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestHistoryAddConcurrent(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	defer testSetenv("XDS_STATE_DIR", dir)()
+
+	const nb = 20
+	var wg sync.WaitGroup
+	for i := 0; i < nb; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := HistoryAdd(HistoryEntry{Cmd: "make"}); err != nil {
+				t.Errorf("HistoryAdd() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := HistoryLoad()
+	if err != nil {
+		t.Fatalf("HistoryLoad() error = %v", err)
+	}
+	if len(entries) != nb {
+		t.Fatalf("HistoryLoad() returned %d entries, want %d", len(entries), nb)
+	}
+	nums := []int{}
+	for _, h := range entries {
+		nums = append(nums, h.Num)
+	}
+	sort.Ints(nums)
+	for i, n := range nums {
+		if n != i+1 {
+			t.Fatalf("entry numbers = %v, want 1..%d", nums, nb)
+		}
+	}
+}
+
+func TestHistoryFileError(t *testing.T) {
+	defer testSetenv("XDS_STATE_DIR", "")()
+	defer testSetenv("XDG_STATE_HOME", "")()
+	defer testSetenv("HOME", "")()
+	defer testSetenv("USERPROFILE", "")()
+	if _, err := HistoryLoad(); err == nil {
+		t.Errorf("HistoryLoad() without state directory returned no error")
+	}
+}
@@ -0,0 +1,414 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// Job status
+const (
+	JobStatusRunning = "running"
+	JobStatusExited  = "exited"
+	JobStatusLost    = "lost" // worker process died before command exit
+)
+
+// JobInfo Definition of a background job started by 'exec --detach'
+type JobInfo struct {
+	ID        string    `json:"id"`
+	PID       int       `json:"pid"`
+	ProjectID string    `json:"projectID"`
+	SdkID     string    `json:"sdkID"`
+	Cmd       string    `json:"cmd"`
+	Args      []string  `json:"args"`
+	RPath     string    `json:"rpath"`
+	Status    string    `json:"status"`
+	ExitCode  int       `json:"exitCode"`
+	Error     string    `json:"error"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+// JobLogWriter Writer of job output that buffers data until log file is opened
+type JobLogWriter struct {
+	sync.Mutex
+	buf  bytes.Buffer
+	file *os.File
+}
+
+func (w *JobLogWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return w.buf.Write(p)
+	}
+	return w.file.Write(p)
+}
+
+// SetFile Set log file and flush buffered data
+func (w *JobLogWriter) SetFile(f *os.File) error {
+	w.Lock()
+	defer w.Unlock()
+	w.file = f
+	_, err := w.buf.WriteTo(f)
+	return err
+}
+
+func initCmdJobs(cmdDef *[]cli.Command) {
+	*cmdDef = append(*cmdDef, cli.Command{
+		Name:     "jobs",
+		Aliases:  []string{"job"},
+		HideHelp: true,
+		Usage:    "background jobs commands group (see exec --detach)",
+		Subcommands: []cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "List background jobs",
+				Action:  jobsList,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "verbose, v",
+						Usage: "display verbose output",
+					},
+				},
+			},
+			{
+				Name:   "attach",
+				Usage:  "Replay output of a job and then follow it until job exits",
+				Action: jobsAttach,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "job id",
+					},
+				},
+			},
+			{
+				Name:   "logs",
+				Usage:  "Display output of a job",
+				Action: jobsLogs,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "job id",
+					},
+				},
+			},
+			{
+				Name:   "wait",
+				Usage:  "Wait end of a job and exit with its exit code",
+				Action: jobsWait,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "job id",
+					},
+				},
+			},
+			{
+				Name:   "kill",
+				Usage:  "Send a signal to a job",
+				Action: jobsKill,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "job id",
+					},
+					cli.StringFlag{
+						Name:  "signal, s",
+						Usage: "signal to send",
+						Value: "SIGTERM",
+					},
+				},
+			},
+		},
+	})
+}
+
+func jobsList(ctx *cli.Context) error {
+	jobs, err := _jobsListGet()
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+
+	writer := NewTableWriter()
+	if ctx.Bool("verbose") {
+		for i, job := range jobs {
+			if i > 0 {
+				fmt.Fprintln(writer)
+			}
+			fmt.Fprintln(writer, "ID:\t", job.ID)
+			fmt.Fprintln(writer, "PID:\t", job.PID)
+			fmt.Fprintln(writer, "Project ID:\t", job.ProjectID)
+			fmt.Fprintln(writer, "Sdk ID:\t", job.SdkID)
+			fmt.Fprintln(writer, "Command:\t", job.Cmd, strings.Join(job.Args, " "))
+			fmt.Fprintln(writer, "Relative path:\t", job.RPath)
+			fmt.Fprintln(writer, "Status:\t", job.Status)
+			fmt.Fprintln(writer, "Exit code:\t", job.ExitCode)
+			fmt.Fprintln(writer, "Start time:\t", job.StartTime.Format(time.RFC3339))
+			if job.Status != JobStatusRunning {
+				fmt.Fprintln(writer, "End time:\t", job.EndTime.Format(time.RFC3339))
+			}
+			if job.Status == JobStatusLost {
+				fmt.Fprintln(writer, "Error:\t", job.Error)
+			}
+		}
+	} else {
+		fmt.Fprintln(writer, "ID\t STATUS\t EXIT CODE\t STARTED\t COMMAND")
+		for _, job := range jobs {
+			code := "-"
+			if job.Status == JobStatusExited {
+				code = fmt.Sprintf("%d", job.ExitCode)
+			}
+			fmt.Fprintln(writer, job.ID, "\t", job.Status, "\t", code, "\t",
+				job.StartTime.Format("2006-01-02 15:04:05"), "\t", job.Cmd, strings.Join(job.Args, " "))
+		}
+	}
+	writer.Flush()
+	return nil
+}
+
+func jobsAttach(ctx *cli.Context) error {
+	job, err := _jobGet(GetID(ctx))
+	if err != nil {
+		return ExitErrorFrom(err, ExitCodeError)
+	}
+
+	f, err := os.Open(_jobLogFile(job.ID))
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	defer f.Close()
+
+	// Ctrl+C only detaches from job, job keeps running in background
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	for {
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return cli.NewExitError(err.Error(), ExitCodeError)
+		}
+		if job.Status == JobStatusExited {
+			// Output is complete once job has exited
+			io.Copy(os.Stdout, f)
+			return NewRemoteExitError(job.Error, job.ExitCode)
+		}
+		if job.Status == JobStatusLost {
+			return cli.NewExitError(job.Error, ExitCodeError)
+		}
+
+		select {
+		case <-sigs:
+			fmt.Fprintf(os.Stderr, "\nDetached from job %s (still running)\n", job.ID)
+			return nil
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		if job, err = _jobInfoRead(job.ID); err != nil {
+			return cli.NewExitError(err.Error(), ExitCodeError)
+		}
+	}
+}
+
+func jobsLogs(ctx *cli.Context) error {
+	job, err := _jobGet(GetID(ctx))
+	if err != nil {
+		return ExitErrorFrom(err, ExitCodeError)
+	}
+
+	f, err := os.Open(_jobLogFile(job.ID))
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(os.Stdout, f); err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	return nil
+}
+
+func jobsWait(ctx *cli.Context) error {
+	job, err := _jobGet(GetID(ctx))
+	if err != nil {
+		return ExitErrorFrom(err, ExitCodeError)
+	}
+
+	for job.Status == JobStatusRunning {
+		time.Sleep(500 * time.Millisecond)
+		if job, err = _jobInfoRead(job.ID); err != nil {
+			return cli.NewExitError(err.Error(), ExitCodeError)
+		}
+	}
+	if job.Status == JobStatusLost {
+		return cli.NewExitError(job.Error, ExitCodeError)
+	}
+	return NewRemoteExitError(job.Error, job.ExitCode)
+}
+
+func jobsKill(ctx *cli.Context) error {
+	job, err := _jobGet(GetID(ctx))
+	if err != nil {
+		return ExitErrorFrom(err, ExitCodeError)
+	}
+	if job.Status != JobStatusRunning {
+		return cli.NewExitError("job "+job.ID+" is not running ("+job.Status+")", ExitCodeInvalidArgs)
+	}
+
+	sigName := strings.ToUpper(ctx.String("signal"))
+	if !strings.HasPrefix(sigName, "SIG") {
+		sigName = "SIG" + sigName
+	}
+	if err := execSignalSend(job.ID, sigName); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	fmt.Printf("%s sent to job %s.\n", sigName, job.ID)
+	return nil
+}
+
+// _jobGet Return job info from a job id or an unique prefix of a job id
+func _jobGet(id string) (JobInfo, error) {
+	if id == "" {
+		return JobInfo{}, cli.NewExitError("id parameter or option must be set", ExitCodeInvalidArgs)
+	}
+	jobs, err := _jobsListGet()
+	if err != nil {
+		return JobInfo{}, err
+	}
+	items := []IDItem{}
+	for _, job := range jobs {
+		items = append(items, IDItem{ID: job.ID})
+	}
+	if id, err = ResolveID("job", id, items); err != nil {
+		return JobInfo{}, err
+	}
+	return _jobInfoRead(id)
+}
+
+// _jobsListGet Return all known jobs sorted by start time
+func _jobsListGet() ([]JobInfo, error) {
+	dir, err := StateDirGet("jobs")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []JobInfo{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		job, err := _jobInfoRead(e.Name())
+		if err != nil {
+			Log.Debugf("Skip invalid job %s: %v", e.Name(), err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartTime.Before(jobs[j].StartTime)
+	})
+	return jobs, nil
+}
+
+func _jobDir(id string) string {
+	dir, _ := StateDirGet("jobs")
+	return filepath.Join(dir, id)
+}
+
+func _jobLogFile(id string) string {
+	return filepath.Join(_jobDir(id), "output.log")
+}
+
+// _jobInfoRead Read job info file, a running job whose worker process no
+// longer exists is marked as lost
+func _jobInfoRead(id string) (JobInfo, error) {
+	job, err := _jobInfoReadFile(id)
+	if err != nil || job.Status != JobStatusRunning || procAlive(job.PID) {
+		return job, err
+	}
+
+	// Worker may have exited right after reading job info
+	if job, err = _jobInfoReadFile(id); err != nil || job.Status != JobStatusRunning {
+		return job, err
+	}
+	job.Status = JobStatusLost
+	job.Error = fmt.Sprintf("job %s lost: worker process %d died before end of command (remote command may still be running)", job.ID, job.PID)
+	job.EndTime = time.Now()
+	Log.Debugf("%s", job.Error)
+	if err := _jobInfoWrite(job); err != nil {
+		Log.Warningf("Cannot update job %s: %v", job.ID, err)
+	}
+	return job, nil
+}
+
+func _jobInfoReadFile(id string) (JobInfo, error) {
+	job := JobInfo{}
+	data, err := ioutil.ReadFile(filepath.Join(_jobDir(id), "job.json"))
+	if err != nil {
+		return job, err
+	}
+	err = json.Unmarshal(data, &job)
+	return job, err
+}
+
+// _jobInfoWrite Atomically write job info file
+func _jobInfoWrite(job JobInfo) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(_jobDir(job.ID), "job.json")
+	if err := ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// _jobCreate Create job directory and open its output log file
+func _jobCreate(job JobInfo, logW *JobLogWriter) error {
+	if err := os.MkdirAll(_jobDir(job.ID), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(_jobLogFile(job.ID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := logW.SetFile(f); err != nil {
+		return err
+	}
+	return _jobInfoWrite(job)
+}
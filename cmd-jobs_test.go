@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// jobTestWrite Create a job in state directory
+func jobTestWrite(t *testing.T, job JobInfo) {
+	if err := _jobCreate(job, &JobLogWriter{}); err != nil {
+		t.Fatalf("_jobCreate(%s) error = %v", job.ID, err)
+	}
+}
+
+func TestJobLogWriter(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	defer testSetenv("XDS_STATE_DIR", dir)()
+
+	w := &JobLogWriter{}
+	w.Write([]byte("before "))
+	if err := _jobCreate(JobInfo{ID: "j1", Status: JobStatusExited}, w); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("after"))
+	w.file.Close()
+
+	data, err := ioutil.ReadFile(_jobLogFile("j1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "before after" {
+		t.Errorf("log file = %q, want %q", data, "before after")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jobs", "j1", "job.json")); err != nil {
+		t.Errorf("job info file not written in state dir: %v", err)
+	}
+}
+
+func TestJobInfoRead(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process liveness is not checked on windows")
+	}
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	defer testSetenv("XDS_STATE_DIR", dir)()
+
+	// Pid of a process that has exited
+	cmd := osexec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run true: %v", err)
+	}
+	deadPID := cmd.Process.Pid
+
+	tests := []struct {
+		name       string
+		job        JobInfo
+		wantStatus string
+	}{
+		{"running worker alive", JobInfo{ID: "alive", PID: os.Getpid(), Status: JobStatusRunning}, JobStatusRunning},
+		{"running worker dead", JobInfo{ID: "dead", PID: deadPID, Status: JobStatusRunning}, JobStatusLost},
+		{"exited worker dead", JobInfo{ID: "exited", PID: deadPID, Status: JobStatusExited, ExitCode: 2}, JobStatusExited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobTestWrite(t, tt.job)
+			job, err := _jobInfoRead(tt.job.ID)
+			if err != nil {
+				t.Fatalf("_jobInfoRead() error = %v", err)
+			}
+			if job.Status != tt.wantStatus || job.ExitCode != tt.job.ExitCode {
+				t.Errorf("_jobInfoRead() status = %s (exit %d), want %s (exit %d)",
+					job.Status, job.ExitCode, tt.wantStatus, tt.job.ExitCode)
+			}
+			// Lost status must be persisted
+			saved, err := _jobInfoReadFile(tt.job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Status != tt.wantStatus {
+				t.Errorf("saved status = %s, want %s", saved.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestJobsListGet(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	defer testSetenv("XDS_STATE_DIR", dir)()
+
+	now := time.Now()
+	jobTestWrite(t, JobInfo{ID: "second", Status: JobStatusExited, StartTime: now})
+	jobTestWrite(t, JobInfo{ID: "first", Status: JobStatusExited, StartTime: now.Add(-time.Hour)})
+	// Invalid entries are skipped
+	if err := os.MkdirAll(filepath.Join(dir, "jobs", "empty"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "jobs", "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := _jobsListGet()
+	if err != nil {
+		t.Fatalf("_jobsListGet() error = %v", err)
+	}
+	ids := []string{}
+	for _, j := range jobs {
+		ids = append(ids, j.ID)
+	}
+	if len(ids) != 2 || ids[0] != "first" || ids[1] != "second" {
+		t.Errorf("_jobsListGet() = %v, want [first second]", ids)
+	}
+}
+
+func TestJobGet(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	defer testSetenv("XDS_STATE_DIR", dir)()
+
+	for _, id := range []string{"a1b2c3", "a1ffff", "c0ffee"} {
+		jobTestWrite(t, JobInfo{ID: id, Status: JobStatusExited})
+	}
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"a1b2c3", "a1b2c3", false},
+		{"a1b", "a1b2c3", false},
+		{"c", "c0ffee", false},
+		{"a1", "", true},
+		{"ff", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		job, err := _jobGet(tt.ref)
+		if (err != nil) != tt.wantErr || job.ID != tt.want {
+			t.Errorf("_jobGet(%q) = %q, %v, want %q (error %v)", tt.ref, job.ID, err, tt.want, tt.wantErr)
+		}
+	}
+}
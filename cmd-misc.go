@@ -65,9 +65,15 @@ func xdsVersion(ctx *cli.Context) error {
 	// Get version
 	ver := xaapiv1.XDSVersion{}
 	if err := XdsVersionGet(&ver); err != nil {
-		return cli.NewExitError(err.Error(), 1)
+		return ExitErrorHTTP(err)
 	}
 
+	return OutputRender(ctx, ver, func(wide bool) {
+		_displayVersion(ver, verbose || wide)
+	})
+}
+
+func _displayVersion(ver xaapiv1.XDSVersion, verbose bool) {
 	writer := NewTableWriter()
 	fmt.Fprintln(writer, "Agent:")
 	fmt.Fprintln(writer, "      ID:\t", ver.Client.ID)
@@ -93,26 +99,32 @@ func xdsVersion(ctx *cli.Context) error {
 		}
 	}
 	writer.Flush()
-
-	return nil
 }
 
 func xdsStatus(ctx *cli.Context) error {
 	cfg := xaapiv1.APIConfig{}
 	if err := XdsConfigGet(&cfg); err != nil {
-		return cli.NewExitError(err.Error(), 1)
+		return ExitErrorHTTP(err)
 	}
 
+	verbose := ctx.Bool("verbose")
+	return OutputRender(ctx, cfg, func(wide bool) {
+		_displayStatus(cfg, verbose || wide)
+	})
+}
+
+func _displayStatus(cfg xaapiv1.APIConfig, verbose bool) {
 	writer := NewTableWriter()
 	fmt.Fprintln(writer, "XDS Server:")
 	for _, svr := range cfg.Servers {
 		fmt.Fprintln(writer, "       ID:\t", svr.ID)
 		fmt.Fprintln(writer, "       URL:\t", svr.URL)
+		if verbose {
+			fmt.Fprintln(writer, "       API URL:\t", svr.APIURL)
+		}
 		fmt.Fprintln(writer, "       Connected:\t", svr.Connected)
 		fmt.Fprintln(writer, "       Connection retry:\t", svr.ConnRetry)
 		fmt.Fprintln(writer, "       Disabled:\t", svr.Disabled)
 	}
 	writer.Flush()
-
-	return nil
 }
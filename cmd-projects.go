@@ -19,8 +19,17 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
 	"github.com/urfave/cli"
@@ -55,6 +64,40 @@ func initCmdProjects(cmdDef *[]cli.Command) {
 						Name:  "type, t",
 						Usage: "project type (pathmap|pm, cloudsync|sc)",
 					},
+					cli.StringFlag{
+						Name:  "sdk",
+						Usage: "default SDK of project (id, unique id prefix, name or query, e.g. arch=aarch64)",
+					},
+					cli.BoolFlag{
+						Name:  "interactive, i",
+						Usage: "prompt for project settings (default when path or type is not set and input is a terminal)",
+					},
+					cli.BoolFlag{
+						Name:  "no-check",
+						Usage: "don't check that server path maps to local path (pathmap type)",
+					},
+				},
+			},
+			{
+				Name:      "fetch",
+				Usage:     "Download files from server-side project tree",
+				ArgsUsage: "<glob pattern>...",
+				Action:    projectsFetch,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "id",
+						Usage:  "project id (or unique id prefix, or label)",
+						EnvVar: "XDS_PROJECT_ID",
+					},
+					cli.StringFlag{
+						Name:   "rpath, p",
+						EnvVar: "XDS_RPATH",
+						Usage:  "relative path into project (patterns are relative to it)",
+					},
+					cli.StringFlag{
+						Name:  "dest, d",
+						Usage: "local destination directory (default project local path)",
+					},
 				},
 			},
 			{
@@ -64,7 +107,7 @@ func initCmdProjects(cmdDef *[]cli.Command) {
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:   "id",
-						Usage:  "project id",
+						Usage:  "project id (or unique id prefix, or label)",
 						EnvVar: "XDS_PROJECT_ID",
 					},
 				},
@@ -89,7 +132,7 @@ func initCmdProjects(cmdDef *[]cli.Command) {
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:   "id",
-						Usage:  "project id",
+						Usage:  "project id (or unique id prefix, or label)",
 						EnvVar: "XDS_PROJECT_ID",
 					},
 					cli.BoolFlag{
@@ -106,9 +149,53 @@ func initCmdProjects(cmdDef *[]cli.Command) {
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:   "id",
-						Usage:  "project id",
+						Usage:  "project id (or unique id prefix, or label)",
+						EnvVar: "XDS_PROJECT_ID",
+					},
+					cli.BoolFlag{
+						Name:  "wait, w",
+						Usage: "wait until synchronization is completed",
+					},
+					cli.StringFlag{
+						Name:   "timeout",
+						EnvVar: "XDS_SYNC_TIMEOUT",
+						Value:  DefaultSyncTimeout,
+						Usage:  "maximum duration to wait for synchronization (e.g. 30s or 5m), 0 means unlimited",
+					},
+				},
+			},
+			{
+				Name:      "update",
+				Aliases:   []string{"up"},
+				Usage:     "Update settings of an existing project",
+				ArgsUsage: "[id]",
+				Action:    projectsUpdate,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "id",
+						Usage:  "project id (or unique id prefix, or label)",
 						EnvVar: "XDS_PROJECT_ID",
 					},
+					cli.StringFlag{
+						Name:  "label, l",
+						Usage: "new project label",
+					},
+					cli.StringFlag{
+						Name:  "default-sdk, sdk",
+						Usage: "new default SDK (id, unique id prefix, name or query), 'none' to unset it",
+					},
+					cli.StringFlag{
+						Name:  "server-path, sp",
+						Usage: "new project server path (only used with pathmap type)",
+					},
+					cli.BoolFlag{
+						Name:  "edit, e",
+						Usage: "edit project settings (JSON) using $EDITOR",
+					},
+					cli.BoolFlag{
+						Name:  "no-check",
+						Usage: "don't check that server path maps to local path (pathmap type)",
+					},
 				},
 			},
 		},
@@ -119,26 +206,31 @@ func projectsList(ctx *cli.Context) error {
 	// Get projects list
 	prjs := []xaapiv1.ProjectConfig{}
 	if err := ProjectsListGet(&prjs); err != nil {
-		return cli.NewExitError(err.Error(), 1)
+		return ExitErrorHTTP(err)
 	}
-	_displayProjects(prjs, ctx.Bool("verbose"))
-	return nil
+	return _displayProjects(ctx, prjs, prjs, ctx.Bool("verbose"))
 }
 
 func projectsGet(ctx *cli.Context) error {
-	id := GetID(ctx)
-	if id == "" {
-		return cli.NewExitError("id parameter or option must be set", 1)
+	id, err := GetIDResolved(ctx, ProjectIDResolve)
+	if err != nil {
+		return ExitErrorHTTP(err)
 	}
 	prjs := make([]xaapiv1.ProjectConfig, 1)
 	if err := HTTPCli.Get("/projects/"+id, &prjs[0]); err != nil {
-		return cli.NewExitError(err, 1)
+		return ExitErrorHTTP(err)
 	}
-	_displayProjects(prjs, true)
-	return nil
+	return _displayProjects(ctx, prjs[0], prjs, true)
 }
 
-func _displayProjects(prjs []xaapiv1.ProjectConfig, verbose bool) {
+// _displayProjects Render data (either a project or a list) using --output format
+func _displayProjects(ctx *cli.Context, data interface{}, prjs []xaapiv1.ProjectConfig, verbose bool) error {
+	return OutputRender(ctx, data, func(wide bool) {
+		_displayProjectsTable(prjs, verbose, wide)
+	})
+}
+
+func _displayProjectsTable(prjs []xaapiv1.ProjectConfig, verbose, wide bool) {
 	// Display result
 	first := true
 	writer := NewTableWriter()
@@ -162,6 +254,12 @@ func _displayProjects(prjs []xaapiv1.ProjectConfig, verbose bool) {
 			}
 			fmt.Fprintln(writer, "Default Sdk:\t", ds)
 
+		} else if wide {
+			if first {
+				fmt.Fprintln(writer, "ID\t Label\t Type\t Status\t InSync\t DefaultSdk\t LocalPath\t ServerPath")
+			}
+			fmt.Fprintln(writer, folder.ID, "\t", folder.Label, "\t", folder.Type, "\t", folder.Status, "\t",
+				folder.IsInSync, "\t", folder.DefaultSdk, "\t", folder.ClientPath, "\t", folder.ServerPath)
 		} else {
 			if first {
 				fmt.Fprintln(writer, "ID\t Label\t LocalPath")
@@ -174,43 +272,392 @@ func _displayProjects(prjs []xaapiv1.ProjectConfig, verbose bool) {
 }
 
 func projectsAdd(ctx *cli.Context) error {
+	prj := xaapiv1.ProjectConfig{
+		ServerID:   XdsServerIDGet(),
+		Label:      ctx.String("label"),
+		ClientPath: ctx.String("path"),
+		ServerPath: ctx.String("server-path"),
+		DefaultSdk: ctx.String("sdk"),
+	}
+	ptype := ctx.String("type")
+
+	interactive := ctx.Bool("interactive")
+	if !interactive && (prj.ClientPath == "" || ptype == "") {
+		if !IsTerminal(os.Stdin) {
+			return cli.NewExitError("path and type options must be set", ExitCodeInvalidArgs)
+		}
+		interactive = true
+	}
+	if interactive {
+		_projectsAddWizard(&prj, &ptype)
+	}
 
 	// Decode project type
-	var ptype xaapiv1.ProjectType
-	switch strings.ToLower(ctx.String("type")) {
+	switch strings.ToLower(ptype) {
 	case "pathmap", "pm":
-		ptype = xaapiv1.TypePathMap
+		prj.Type = xaapiv1.TypePathMap
 	case "cloudsync", "cs":
-		ptype = xaapiv1.TypeCloudSync
+		prj.Type = xaapiv1.TypeCloudSync
 	default:
-		return cli.NewExitError("Unknown project type", 1)
+		return cli.NewExitError("Unknown project type", ExitCodeInvalidArgs)
 	}
 
-	prj := xaapiv1.ProjectConfig{
-		ServerID:   XdsServerIDGet(),
-		Label:      ctx.String("label"),
-		Type:       ptype,
-		ClientPath: ctx.String("path"),
-		ServerPath: ctx.String("server-path"),
+	prjs := []xaapiv1.ProjectConfig{}
+	if err := ProjectsListGet(&prjs); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	if err := _projectAddCheck(&prj, prjs); err != nil {
+		return err
+	}
+	if prj.DefaultSdk != "" {
+		sdk, err := SdkSelect(prj.DefaultSdk, xaapiv1.ProjectConfig{})
+		if err != nil {
+			return ExitErrorHTTP(err)
+		}
+		prj.DefaultSdk = sdk.ID
+	}
+
+	newPrj, err := _projectCreate(prj, prjs, !ctx.Bool("no-check"))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("New project '%s' (id %v) successfully created.\n", newPrj.Label, newPrj.ID)
+
+	return nil
+}
+
+// _projectAddCheck Check settings of a new project against existing projects
+// (prjs): local path must be an unused directory (made absolute) and server
+// path must be set for pathmap projects; label defaults to local directory name
+func _projectAddCheck(prj *xaapiv1.ProjectConfig, prjs []xaapiv1.ProjectConfig) error {
+	if prj.ClientPath == "" {
+		return cli.NewExitError("path option must be set", ExitCodeInvalidArgs)
+	}
+	path, err := filepath.Abs(prj.ClientPath)
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
+	}
+	prj.ClientPath = path
+	if fi, err := os.Stat(path); err != nil {
+		return cli.NewExitError("invalid local path: "+err.Error(), ExitCodeInvalidArgs)
+	} else if !fi.IsDir() {
+		return cli.NewExitError("local path "+path+" is not a directory", ExitCodeInvalidArgs)
+	}
+	if prj.Label == "" {
+		prj.Label = filepath.Base(path)
+	}
+	if prj.Type == xaapiv1.TypePathMap && prj.ServerPath == "" {
+		return cli.NewExitError("server-path option must be set with pathmap type", ExitCodeInvalidArgs)
+	}
+
+	// Refuse duplicate local path
+	for _, p := range prjs {
+		if filepath.Clean(p.ClientPath) == path {
+			return cli.NewExitError(fmt.Sprintf("local path %s is already used by project '%s' (id %s)", path, p.Label, p.ID), ExitCodeInvalidArgs)
+		}
+	}
+	return nil
+}
+
+// _projectCreate Create a project (checked by _projectAddCheck); when check is
+// set, path mapping of a pathmap project is checked before creation using one
+// of existing projects (prjs) to read a check file on server side
+func _projectCreate(prj xaapiv1.ProjectConfig, prjs []xaapiv1.ProjectConfig, check bool) (xaapiv1.ProjectConfig, error) {
+	if prj.Type == xaapiv1.TypePathMap && check {
+		runner := _projectCheckRunner(prj.ServerID, prjs)
+		if runner == nil {
+			fmt.Fprintf(os.Stderr, "WARNING: path mapping of %s not checked, no existing project to run check command on server\n", prj.ClientPath)
+		} else if err := _projectPathMapCheck(prj, *runner); err != nil {
+			return xaapiv1.ProjectConfig{}, cli.NewExitError(fmt.Sprintf("server path %s doesn't map local path %s (use --no-check to skip this check): %v",
+				prj.ServerPath, prj.ClientPath, err), ExitCodeInvalidArgs)
+		}
 	}
 
 	Log.Infof("POST /project %v", prj)
 	newPrj := xaapiv1.ProjectConfig{}
-	err := HTTPCli.Post("/projects", prj, &newPrj)
+	if err := HTTPCli.Post("/projects", prj, &newPrj); err != nil {
+		return newPrj, ExitErrorHTTP(err)
+	}
+	return newPrj, nil
+}
+
+// _projectCheckRunner Return a project of XDS server serverID that can run
+// check commands (nil if none)
+func _projectCheckRunner(serverID string, prjs []xaapiv1.ProjectConfig) *xaapiv1.ProjectConfig {
+	for i := range prjs {
+		if prjs[i].ServerID == serverID && prjs[i].Status != xaapiv1.StatusErrorConfig {
+			return &prjs[i]
+		}
+	}
+	return nil
+}
+
+// _projectsAddWizard Prompt user for project settings, values already set are
+// used as default answers
+func _projectsAddWizard(prj *xaapiv1.ProjectConfig, ptype *string) {
+	if prj.ClientPath == "" {
+		prj.ClientPath, _ = os.Getwd()
+	}
+	prj.ClientPath = Prompt("Project local path", prj.ClientPath)
+	if abs, err := filepath.Abs(prj.ClientPath); err == nil {
+		prj.ClientPath = abs
+	}
+
+	if *ptype == "" {
+		*ptype = "cloudsync"
+	}
+	*ptype = Prompt("Project type (pathmap|pm, cloudsync|cs)", *ptype)
+	switch strings.ToLower(*ptype) {
+	case "pathmap", "pm":
+		prj.ServerPath = Prompt("Project server path", prj.ServerPath)
+	}
+
+	if prj.Label == "" {
+		prj.Label = filepath.Base(prj.ClientPath)
+	}
+	prj.Label = Prompt("Project label", prj.Label)
+
+	if prj.DefaultSdk == "" {
+		sdks := []xaapiv1.SDK{}
+		if err := _sdksListGet(&sdks); err == nil {
+			fmt.Println(strings.TrimPrefix(_sdksCandidates("Installed SDKs", sdks, true), "\n"))
+		}
+	}
+	prj.DefaultSdk = Prompt("Default SDK (id, name or query, empty for none)", prj.DefaultSdk)
+}
+
+// _projectPathMapCheck Check that server path of a pathmap project maps to
+// its local path: a file is created locally then read on server side by a
+// command executed in runner project (prj may not be created yet)
+func _projectPathMapCheck(prj, runner xaapiv1.ProjectConfig) error {
+	if err := execEventsInit(0, os.Stderr); err != nil {
+		return err
+	}
+
+	token := fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+	name := ".xds-pathmap-check-" + token
+	file := filepath.Join(prj.ClientPath, name)
+	if err := ioutil.WriteFile(file, []byte(token), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(file)
+
+	out, err := fetchRemoteOutput(FetchArgs{Project: runner}, "cat "+ShellQuote(path.Join(prj.ServerPath, name)))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) != token {
+		return fmt.Errorf("unexpected content of check file")
+	}
+	Log.Debugf("Path mapping of %s checked", prj.ClientPath)
+	return nil
+}
+
+func projectsUpdate(ctx *cli.Context) error {
+	id, err := GetIDResolved(ctx, ProjectIDResolve)
+	if err != nil {
+		return ExitErrorHTTP(err)
+	}
+	curPrj := xaapiv1.ProjectConfig{}
+	if err := HTTPCli.Get("/projects/"+id, &curPrj); err != nil {
+		return ExitErrorHTTP(err)
+	}
+
+	prj := curPrj
+	if ctx.IsSet("label") {
+		prj.Label = ctx.String("label")
+	}
+	if ctx.IsSet("default-sdk") {
+		prj.DefaultSdk = ctx.String("default-sdk")
+	}
+	if ctx.IsSet("server-path") {
+		prj.ServerPath = ctx.String("server-path")
+	}
+	if ctx.Bool("edit") {
+		if prj, err = _projectEdit(prj); err != nil {
+			return ExitErrorFrom(err, ExitCodeError)
+		}
+	} else if !ctx.IsSet("label") && !ctx.IsSet("default-sdk") && !ctx.IsSet("server-path") {
+		return cli.NewExitError("nothing to update (use --label, --default-sdk, --server-path or --edit)", ExitCodeInvalidArgs)
+	}
+
+	if err := _projectUpdateCheck(curPrj, &prj); err != nil {
+		return ExitErrorFrom(err, ExitCodeInvalidArgs)
+	}
+	if reflect.DeepEqual(prj, curPrj) {
+		fmt.Println("No change, project not updated.")
+		return nil
+	}
+
+	// Check new path mapping before updating project
+	if prj.Type == xaapiv1.TypePathMap && prj.ServerPath != curPrj.ServerPath && !ctx.Bool("no-check") {
+		if err := _projectPathMapCheck(prj, curPrj); err != nil {
+			return cli.NewExitError(fmt.Sprintf("server path %s doesn't map local path %s (use --no-check to skip this check): %v",
+				prj.ServerPath, prj.ClientPath, err), ExitCodeInvalidArgs)
+		}
+	}
+
+	Log.Infof("PUT /projects/%s %v", id, prj)
+	newPrj := xaapiv1.ProjectConfig{}
+	if err := HTTPCli.Put("/projects/"+id, prj, &newPrj); err != nil {
+		return ExitErrorHTTP(err)
+	}
+
+	fmt.Printf("Project '%s' (id %v) successfully updated.\n", newPrj.Label, newPrj.ID)
+	return nil
+}
+
+// _projectEdit Edit project settings using $EDITOR, edition is proposed again
+// when JSON is invalid
+func _projectEdit(prj xaapiv1.ProjectConfig) (xaapiv1.ProjectConfig, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	data, err := json.MarshalIndent(prj, "", "  ")
+	if err != nil {
+		return prj, err
+	}
+	// Temporary file is named *.json for editors syntax highlighting
+	dir, err := ioutil.TempDir("", "xds-project-")
 	if err != nil {
-		return cli.NewExitError(err, 1)
+		return prj, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "project.json")
+	if err := ioutil.WriteFile(file, append(data, '\n'), 0600); err != nil {
+		return prj, err
 	}
 
-	fmt.Printf("New project '%s' (id %v) successfully created.\n", newPrj.Label, newPrj.ID)
+	for {
+		// Editor may contain arguments (e.g. "code --wait")
+		cmd := osexec.Command("sh", "-c", editor+" "+ShellQuote(file))
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return prj, fmt.Errorf("editor '%s' failed: %v", editor, err)
+		}
 
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return prj, err
+		}
+		newPrj := xaapiv1.ProjectConfig{}
+		if err = _jsonFieldsCheck(data, newPrj); err == nil {
+			if err = json.Unmarshal(data, &newPrj); err == nil {
+				return newPrj, nil
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Invalid project settings: %v\n", err)
+		if !Confirm("Edit again [yes/No] ? ") {
+			return prj, cli.NewExitError("project not updated", ExitCodeInvalidArgs)
+		}
+	}
+}
+
+// _jsonFieldsCheck Return an error when a json object holds a field unknown
+// in v struct (json.Decoder.DisallowUnknownFields needs Go 1.10)
+func _jsonFieldsCheck(data []byte, v interface{}) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		known[strings.ToLower(name)] = true
+	}
+	for name := range fields {
+		if !known[strings.ToLower(name)] {
+			return fmt.Errorf("unknown field \"%s\"", name)
+		}
+	}
+	return nil
+}
+
+// _projectUpdateCheck Check new settings of a project and resolve its default SDK
+func _projectUpdateCheck(cur xaapiv1.ProjectConfig, prj *xaapiv1.ProjectConfig) error {
+	if prj.ID != cur.ID || prj.ServerID != cur.ServerID || prj.Type != cur.Type {
+		return fmt.Errorf("id, serverId and type of a project cannot be changed")
+	}
+	if strings.TrimSpace(prj.Label) == "" {
+		return fmt.Errorf("project label cannot be empty")
+	}
+	if prj.ClientPath != cur.ClientPath {
+		if fi, err := os.Stat(prj.ClientPath); err != nil || !fi.IsDir() {
+			return fmt.Errorf("local path %s is not a directory", prj.ClientPath)
+		}
+	}
+	if prj.Type == xaapiv1.TypePathMap && prj.ServerPath == "" {
+		return fmt.Errorf("server path cannot be empty with pathmap type")
+	}
+
+	// Fields updated by server are kept as is
+	prj.Status = cur.Status
+	prj.IsInSync = cur.IsInSync
+
+	switch strings.ToLower(prj.DefaultSdk) {
+	case "", "none", "-":
+		prj.DefaultSdk = ""
+	case strings.ToLower(cur.DefaultSdk):
+		prj.DefaultSdk = cur.DefaultSdk
+	default:
+		sdk, err := SdkSelect(prj.DefaultSdk, xaapiv1.ProjectConfig{})
+		if err != nil {
+			return err
+		}
+		prj.DefaultSdk = sdk.ID
+	}
+	return nil
+}
+
+func projectsFetch(ctx *cli.Context) error {
+	id := ctx.String("id")
+	if id == "" {
+		return cli.NewExitError("id option must be set", ExitCodeInvalidArgs)
+	}
+	if ctx.NArg() == 0 {
+		return cli.NewExitError("at least one glob pattern must be set", ExitCodeInvalidArgs)
+	}
+	reconnectTimeout, err := ParseTimeout(ctx.GlobalString("reconnect-timeout"))
+	if err != nil {
+		return cli.NewExitError("--reconnect-timeout: "+err.Error(), ExitCodeInvalidArgs)
+	}
+	if id, err = ProjectIDResolve(id); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	prj := xaapiv1.ProjectConfig{}
+	if err := HTTPCli.Get("/projects/"+id, &prj); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	if err := execEventsInit(reconnectTimeout, os.Stderr); err != nil {
+		return ExitErrorHTTP(err)
+	}
+
+	_, err = ProjectFetch(FetchArgs{
+		Project:  prj,
+		RPath:    ctx.String("rpath"),
+		Patterns: ctx.Args(),
+		DestDir:  ctx.String("dest"),
+	})
+	if err != nil {
+		return ExitErrorFrom(err, ExitCodeError)
+	}
 	return nil
 }
 
 func projectsRemove(ctx *cli.Context) error {
 	var res xaapiv1.ProjectConfig
-	id := GetID(ctx)
-	if id == "" {
-		return cli.NewExitError("id parameter or option must be set", 1)
+	id, err := GetIDResolved(ctx, ProjectIDResolve)
+	if err != nil {
+		return ExitErrorHTTP(err)
 	}
 
 	if !ctx.Bool("force") {
@@ -220,7 +667,7 @@ func projectsRemove(ctx *cli.Context) error {
 	}
 
 	if err := HTTPCli.Delete("/projects/"+id, &res); err != nil {
-		return cli.NewExitError(err, 1)
+		return ExitErrorHTTP(err)
 	}
 
 	fmt.Println("Project ID " + res.ID + " successfully deleted.")
@@ -228,13 +675,134 @@ func projectsRemove(ctx *cli.Context) error {
 }
 
 func projectsSync(ctx *cli.Context) error {
-	id := GetID(ctx)
-	if id == "" {
-		return cli.NewExitError("id parameter or option must be set", 1)
+	id, err := GetIDResolved(ctx, ProjectIDResolve)
+	if err != nil {
+		return ExitErrorHTTP(err)
+	}
+	timeout, err := ParseTimeout(ctx.String("timeout"))
+	if err != nil {
+		return cli.NewExitError("--timeout: "+err.Error(), ExitCodeInvalidArgs)
+	}
+	if !ctx.Bool("wait") {
+		if err := HTTPCli.Post("/projects/sync/"+id, "", nil); err != nil {
+			return ExitErrorHTTP(err)
+		}
+		fmt.Println("Sync successfully resquested.")
+		return nil
 	}
-	if err := HTTPCli.Post("/projects/sync/"+id, "", nil); err != nil {
-		return cli.NewExitError(err, 1)
+	if err := ProjectSyncRequest(id); err != nil {
+		return ExitErrorHTTP(err)
 	}
-	fmt.Println("Sync successfully resquested.")
+	if err := ProjectSyncWait(id, timeout, true); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	fmt.Println("Sync successfully completed.")
 	return nil
 }
+
+// DefaultSyncTimeout Default maximum duration to wait for project sync
+const DefaultSyncTimeout = "5m"
+
+var prjEventsOnce sync.Once
+var prjEventsErr error
+var prjChangeLock sync.Mutex
+var prjChanged = make(map[string]bool)
+var prjChangeChan = make(chan bool, 1)
+
+// projectEventsInit Register (only once) to project change events
+func projectEventsInit() error {
+	prjEventsOnce.Do(func() {
+		IOskOn(xaapiv1.EVTProjectChange, func(ev xaapiv1.EventMsg) {
+			prj, _ := ev.DecodeProjectConfig()
+			Log.Infof("Event %v (%v): %v", ev.Type, ev.Time, prj)
+			prjChangeLock.Lock()
+			prjChanged[prj.ID] = true
+			prjChangeLock.Unlock()
+			select {
+			case prjChangeChan <- true:
+			default:
+			}
+		})
+		prjEventsErr = IOskEventRegister(xaapiv1.EVTProjectChange)
+	})
+	return prjEventsErr
+}
+
+// _projectChangedTake Return (and reset) whether a change event of a project
+// has been received
+func _projectChangedTake(id string) bool {
+	prjChangeLock.Lock()
+	defer prjChangeLock.Unlock()
+	changed := prjChanged[id]
+	delete(prjChanged, id)
+	return changed
+}
+
+// ProjectSyncRequest Request a synchronization of project sources, project
+// events received before the request are ignored by ProjectSyncWait
+func ProjectSyncRequest(id string) error {
+	if err := projectEventsInit(); err != nil {
+		return err
+	}
+	_projectChangedTake(id)
+	return HTTPCli.Post("/projects/sync/"+id, "", nil)
+}
+
+// ProjectSyncWait Wait until a project is in sync (timeout set to 0 means no
+// timeout). When a sync has just been requested, in sync state is only
+// trusted once project has changed (change event or out of sync state seen)
+// as it may still be the one of before the request.
+func ProjectSyncWait(id string, timeout time.Duration, requested bool) error {
+	if err := projectEventsInit(); err != nil {
+		return err
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	spinner := `|/-\`
+	showSpinner := IsTerminal(os.Stderr)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	defer func() {
+		if showSpinner {
+			fmt.Fprintf(os.Stderr, "\r\033[K")
+		}
+	}()
+
+	// Project state is checked on each project change event and at least
+	// every 2 seconds
+	changed := !requested
+	for tick := 0; ; tick++ {
+		if tick%8 == 0 {
+			changed = _projectChangedTake(id) || changed
+			prj := xaapiv1.ProjectConfig{}
+			if err := HTTPCli.Get("/projects/"+id, &prj); err != nil {
+				return err
+			}
+			if !prj.IsInSync || prj.Type != xaapiv1.TypeCloudSync {
+				// Nothing to wait for pathmap projects
+				changed = true
+			}
+			if prj.IsInSync && changed {
+				Log.Debugf("Project %s in sync", id)
+				return nil
+			}
+			if prj.Status == xaapiv1.StatusErrorConfig {
+				return cli.NewExitError(fmt.Sprintf("project %s cannot be synchronized (status %s)", id, prj.Status), ExitCodeRemote)
+			}
+		}
+		if showSpinner {
+			fmt.Fprintf(os.Stderr, "\rWaiting for project sources synchronization %c", spinner[tick%len(spinner)])
+		}
+
+		select {
+		case <-prjChangeChan:
+			tick = -1
+		case <-ticker.C:
+		case <-deadline:
+			return cli.NewExitError(fmt.Sprintf("timeout while waiting for project %s synchronization", id), ExitCodeTimeout)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestJSONFieldsCheck(t *testing.T) {
+	type settings struct {
+		ID      string `json:"id"`
+		Label   string `json:"label,omitempty"`
+		Comment string
+	}
+	tests := []struct {
+		data    string
+		wantErr bool
+	}{
+		{`{}`, false},
+		{`{"id": "1", "label": "x", "Comment": "c"}`, false},
+		{`{"ID": "1", "comment": "c"}`, false},
+		{`{"id": "1", "lable": "x"}`, true},
+		{`{"id": "1"`, true},
+		{`[]`, true},
+	}
+	for _, tt := range tests {
+		err := _jsonFieldsCheck([]byte(tt.data), settings{})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("_jsonFieldsCheck(%s) error = %v, wantErr %v", tt.data, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProjectCheckRunner(t *testing.T) {
+	prjs := []xaapiv1.ProjectConfig{
+		{ID: "p1", ServerID: "srv1", Status: xaapiv1.StatusErrorConfig},
+		{ID: "p2", ServerID: "srv2", Status: xaapiv1.StatusEnable},
+		{ID: "p3", ServerID: "srv1", Status: xaapiv1.StatusEnable},
+	}
+	tests := []struct {
+		serverID string
+		want     string
+	}{
+		{"srv1", "p3"},
+		{"srv2", "p2"},
+		{"srv3", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if p := _projectCheckRunner(tt.serverID, prjs); p != nil {
+			got = p.ID
+		}
+		if got != tt.want {
+			t.Errorf("_projectCheckRunner(%q) = %q, want %q", tt.serverID, got, tt.want)
+		}
+	}
+	if p := _projectCheckRunner("srv1", nil); p != nil {
+		t.Errorf("_projectCheckRunner() without projects = %v", p)
+	}
+}
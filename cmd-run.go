@@ -0,0 +1,264 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// RecipesFileName Name of project recipes file, searched from current
+// directory up to root directory
+const RecipesFileName = "xds-recipes.json"
+
+// Recipe Named command declared in a project recipes file
+type Recipe struct {
+	Description string            `json:"description"`
+	Cmd         string            `json:"cmd"`
+	Args        []string          `json:"args"`
+	RPath       string            `json:"rpath"`
+	SdkID       string            `json:"sdkid"`
+	Env         map[string]string `json:"env"`
+	Profiles    map[string]Recipe `json:"profiles"`
+}
+
+// RecipesFile Content of a project recipes file
+type RecipesFile struct {
+	ProjectID      string            `json:"projectID"`
+	DefaultProfile string            `json:"defaultProfile"`
+	Recipes        map[string]Recipe `json:"recipes"`
+}
+
+func initCmdRun(cmdDef *[]cli.Command) {
+	flags := append(execFlags(),
+		cli.StringFlag{
+			Name:   "recipes, r",
+			EnvVar: "XDS_RECIPES_FILE",
+			Usage:  "project recipes file (default " + RecipesFileName + " of current directory or of a parent directory)",
+		},
+		cli.StringFlag{
+			Name:   "profile",
+			EnvVar: "XDS_PROFILE",
+			Usage:  "recipe profile to use (e.g. debug or release)",
+		},
+		cli.BoolFlag{
+			Name:  "list",
+			Usage: "list available recipes",
+		},
+	)
+
+	*cmdDef = append(*cmdDef, cli.Command{
+		Name:      "run",
+		Usage:     "execute a named command recipe of project",
+		ArgsUsage: "<recipe> [-- extra args]",
+		Action:    runRecipe,
+		Description: `Recipes are declared in a versioned project file (` + RecipesFileName + `).
+   A recipe defines command, args, rpath, sdkid and env, each of them may be
+   overwritten by a profile of the recipe. Extra args are added to recipe args.
+   Options and XDS_xxx variables take precedence over recipe settings, and recipe
+   env takes precedence over config file variables (see exec command for details).
+
+   Example of recipes file:
+     {
+       "projectID": "...",
+       "defaultProfile": "debug",
+       "recipes": {
+         "build": {
+           "description": "Build project",
+           "cmd": "make", "args": ["-C", "build"],
+           "profiles": {
+             "debug": { "env": { "BUILD_TYPE": "Debug" } },
+             "release": { "env": { "BUILD_TYPE": "Release" } }
+           }
+         }
+       }
+     }`,
+		Flags: flags,
+	})
+}
+
+func runRecipe(ctx *cli.Context) error {
+	file := ctx.String("recipes")
+	if file == "" {
+		var err error
+		if file, err = RecipesFileFind(); err != nil {
+			return cli.NewExitError(err.Error(), ExitCodeNotFound)
+		}
+	}
+	recipes, err := RecipesFileLoad(file)
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
+	}
+
+	if ctx.Bool("list") {
+		_displayRecipes(recipes)
+		return nil
+	}
+
+	name := ctx.Args().First()
+	if name == "" {
+		return cli.NewExitError("recipe name must be set (see --list option)", ExitCodeInvalidArgs)
+	}
+	profile := ctx.String("profile")
+	if profile == "" {
+		profile = recipes.DefaultProfile
+	}
+	recipe, err := recipes.Get(name, profile)
+	if err != nil {
+		return ExitErrorFrom(err, ExitCodeNotFound)
+	}
+	Log.Infof("Run recipe %s (profile '%s'): %+v", name, profile, recipe)
+
+	// Recipe settings are only used when not set by option or variable
+	settings := map[string]string{
+		"id":    recipes.ProjectID,
+		"rpath": recipe.RPath,
+		"sdkid": recipe.SdkID,
+	}
+	for opt, val := range settings {
+		if val != "" && !ctx.IsSet(opt) {
+			if err := ctx.Set(opt, val); err != nil {
+				return cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
+			}
+		}
+	}
+	if len(recipe.Env) > 0 {
+		if EnvConfFileMap == nil {
+			EnvConfFileMap = make(map[string]string)
+		}
+		for k, v := range recipe.Env {
+			EnvConfFileMap[k] = v
+		}
+	}
+
+	argsCommand := append([]string{recipe.Cmd}, recipe.Args...)
+	argsCommand = append(argsCommand, ctx.Args().Tail()...)
+	return execRun(ctx, argsCommand)
+}
+
+// RecipesFileFind Search recipes file from current directory up to root directory
+func RecipesFileFind() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		file := filepath.Join(dir, RecipesFileName)
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no %s file found (see --recipes option)", RecipesFileName)
+		}
+		dir = parent
+	}
+}
+
+// RecipesFileLoad Load and check a recipes file
+func RecipesFileLoad(file string) (*RecipesFile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	recipes := RecipesFile{}
+	if err := json.Unmarshal(data, &recipes); err != nil {
+		return nil, fmt.Errorf("invalid recipes file %s: %v", file, err)
+	}
+	for name, r := range recipes.Recipes {
+		if r.Cmd == "" {
+			return nil, fmt.Errorf("invalid recipes file %s: no cmd set for recipe '%s'", file, name)
+		}
+	}
+	Log.Debugf("Recipes loaded from %s", file)
+	return &recipes, nil
+}
+
+// Get Return a recipe after applying settings of a profile
+func (rf *RecipesFile) Get(name, profile string) (Recipe, error) {
+	r, ok := rf.Recipes[name]
+	if !ok {
+		return r, cli.NewExitError(fmt.Sprintf("unknown recipe '%s' (see --list option)", name), ExitCodeNotFound)
+	}
+	if profile == "" {
+		return r, nil
+	}
+	p, ok := r.Profiles[profile]
+	if !ok {
+		if rf.DefaultProfile == profile {
+			// default profile is not necessarily defined by every recipe
+			return r, nil
+		}
+		return r, cli.NewExitError(fmt.Sprintf("unknown profile '%s' for recipe '%s'", profile, name), ExitCodeNotFound)
+	}
+
+	if p.Cmd != "" {
+		r.Cmd = p.Cmd
+	}
+	if p.Args != nil {
+		r.Args = p.Args
+	}
+	if p.RPath != "" {
+		r.RPath = p.RPath
+	}
+	if p.SdkID != "" {
+		r.SdkID = p.SdkID
+	}
+	env := make(map[string]string)
+	for k, v := range r.Env {
+		env[k] = v
+	}
+	for k, v := range p.Env {
+		env[k] = v
+	}
+	r.Env = env
+	return r, nil
+}
+
+func _displayRecipes(rf *RecipesFile) {
+	names := make([]string, 0, len(rf.Recipes))
+	for name := range rf.Recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writer := NewTableWriter()
+	fmt.Fprintln(writer, "RECIPE\t PROFILES\t COMMAND\t DESCRIPTION")
+	for _, name := range names {
+		r := rf.Recipes[name]
+		profiles := []string{}
+		for p := range r.Profiles {
+			profiles = append(profiles, p)
+		}
+		sort.Strings(profiles)
+		prof := strings.Join(profiles, ",")
+		if prof == "" {
+			prof = "-"
+		}
+		fmt.Fprintln(writer, name, "\t", prof, "\t", r.Cmd, strings.Join(r.Args, " "), "\t", r.Description)
+	}
+	writer.Flush()
+}
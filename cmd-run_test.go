@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+const runTestRecipes = `{
+  "projectID": "prj1",
+  "defaultProfile": "debug",
+  "recipes": {
+    "build": {
+      "cmd": "make", "args": ["all"], "rpath": "src",
+      "env": { "CC": "gcc", "BUILD_TYPE": "None" },
+      "profiles": {
+        "debug": { "env": { "BUILD_TYPE": "Debug" } },
+        "cross": { "cmd": "cross-make", "args": [], "sdkid": "sdk-arm", "rpath": "build" }
+      }
+    },
+    "test": { "cmd": "ctest" }
+  }
+}`
+
+func TestRecipesFileLoad(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid", runTestRecipes, false},
+		{"empty", `{}`, false},
+		{"invalid json", `{"recipes": [}`, true},
+		{"recipe without cmd", `{"recipes": {"build": {"args": ["all"]}}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.name+".json")
+			if err := ioutil.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := RecipesFileLoad(file)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RecipesFileLoad() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := RecipesFileLoad(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("RecipesFileLoad() of missing file returned no error")
+	}
+}
+
+func TestRecipesFileGet(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	file := filepath.Join(dir, RecipesFileName)
+	if err := ioutil.WriteFile(file, []byte(runTestRecipes), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := RecipesFileLoad(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, recipe, profile string
+		want                  Recipe
+		wantErr               bool
+	}{
+		{"no profile", "build", "", Recipe{Cmd: "make", Args: []string{"all"}, RPath: "src",
+			Env: map[string]string{"CC": "gcc", "BUILD_TYPE": "None"}}, false},
+		{"profile merges env", "build", "debug", Recipe{Cmd: "make", Args: []string{"all"}, RPath: "src",
+			Env: map[string]string{"CC": "gcc", "BUILD_TYPE": "Debug"}}, false},
+		{"profile overrides settings", "build", "cross", Recipe{Cmd: "cross-make", Args: []string{}, RPath: "build",
+			SdkID: "sdk-arm", Env: map[string]string{"CC": "gcc", "BUILD_TYPE": "None"}}, false},
+		{"default profile not defined by recipe", "test", "debug", Recipe{Cmd: "ctest"}, false},
+		{"unknown profile", "build", "release", Recipe{}, true},
+		{"unknown recipe", "deploy", "", Recipe{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rf.Get(tt.recipe, tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if e, ok := err.(cli.ExitCoder); !ok || e.ExitCode() != ExitCodeNotFound {
+					t.Errorf("Get() error = %v, want exit code %d", err, ExitCodeNotFound)
+				}
+				return
+			}
+			got.Profiles = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Get() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecipesFileFind(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	sub := filepath.Join(dir, "src", "lib")
+	if err := os.MkdirAll(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, RecipesFileName)
+	if err := ioutil.WriteFile(file, []byte(runTestRecipes), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(sub); err != nil {
+		t.Fatal(err)
+	}
+	got, err := RecipesFileFind()
+	if err != nil {
+		t.Fatalf("RecipesFileFind() error = %v", err)
+	}
+	// Temp dir may be a symbolic link (e.g. macOS)
+	gotFi, err := os.Stat(got)
+	if err != nil {
+		t.Fatalf("RecipesFileFind() = %s: %v", got, err)
+	}
+	wantFi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(gotFi, wantFi) {
+		t.Errorf("RecipesFileFind() = %s, want %s", got, file)
+	}
+}
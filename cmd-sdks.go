@@ -21,7 +21,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
 	"github.com/urfave/cli"
@@ -41,7 +45,7 @@ func initCmdSdks(cmdDef *[]cli.Command) {
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:   "id",
-						Usage:  "sdk id",
+						Usage:  "sdk id (or unique id prefix, or name)",
 						EnvVar: "XDS_SDK_ID",
 					},
 				},
@@ -74,7 +78,7 @@ func initCmdSdks(cmdDef *[]cli.Command) {
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:   "id",
-						Usage:  "sdk id to install",
+						Usage:  "sdk id to install (or unique id prefix, or name)",
 						EnvVar: "XDS_SDK_ID",
 					},
 					cli.StringFlag{
@@ -89,6 +93,10 @@ func initCmdSdks(cmdDef *[]cli.Command) {
 						Name:  "force",
 						Usage: "force SDK installation when already installed",
 					},
+					cli.BoolFlag{
+						Name:  "follow",
+						Usage: "follow an installation in progress (e.g. continued in background)",
+					},
 				},
 			},
 			{
@@ -99,7 +107,7 @@ func initCmdSdks(cmdDef *[]cli.Command) {
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:   "id",
-						Usage:  "sdk id to un-install",
+						Usage:  "sdk id to un-install (or unique id prefix, or name)",
 						EnvVar: "XDS_SDK_ID",
 					},
 					cli.BoolFlag{
@@ -116,7 +124,7 @@ func initCmdSdks(cmdDef *[]cli.Command) {
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:   "id",
-						Usage:  "sdk id to which abort action",
+						Usage:  "sdk id to which abort action (or unique id prefix, or name)",
 						EnvVar: "XDS_SDK_ID",
 					},
 				},
@@ -129,45 +137,57 @@ func sdksList(ctx *cli.Context) error {
 	// Get SDKs list
 	sdks := []xaapiv1.SDK{}
 	if err := _sdksListGet(&sdks); err != nil {
-		return cli.NewExitError(err.Error(), 1)
+		return ExitErrorHTTP(err)
 	}
 
-	_displaySdks(sdks, ctx.Bool("verbose"), ctx.Bool("all"), ctx.String("filter"))
-	return nil
+	filter := ctx.String("filter")
+	var re *regexp.Regexp
+	if filter != "" {
+		var err error
+		if re, err = regexp.Compile(filter); err != nil {
+			return cli.NewExitError("invalid --filter regexp: "+err.Error(), ExitCodeInvalidArgs)
+		}
+	}
+	found := []xaapiv1.SDK{}
+	for _, s := range sdks {
+		if s.Status != xaapiv1.SdkStatusInstalled && !ctx.Bool("all") {
+			continue
+		}
+		if re != nil && !_sdkMatchFilter(s, re) {
+			continue
+		}
+		found = append(found, s)
+	}
+
+	return _displaySdks(ctx, found, found, ctx.Bool("verbose"), ctx.Bool("all"))
 }
 
 func sdksGet(ctx *cli.Context) error {
-	id := GetID(ctx)
-	if id == "" {
-		return cli.NewExitError("id parameter or option must be set", 1)
+	id, err := GetIDResolved(ctx, SdkIDResolve)
+	if err != nil {
+		return ExitErrorHTTP(err)
 	}
 	sdks := xaapiv1.SDK{}
 	url := XdsServerComputeURL("/sdks/" + id)
 	if err := HTTPCli.Get(url, &sdks); err != nil {
-		return cli.NewExitError(err.Error(), 1)
+		return ExitErrorHTTP(err)
 	}
 
-	_displaySdks([]xaapiv1.SDK{sdks}, true, true, "")
-	return nil
+	return _displaySdks(ctx, sdks, []xaapiv1.SDK{sdks}, true, true)
+}
+
+// _displaySdks Render data (either a SDK or a list) using --output format
+func _displaySdks(ctx *cli.Context, data interface{}, sdks []xaapiv1.SDK, verbose bool, all bool) error {
+	return OutputRender(ctx, data, func(wide bool) {
+		_displaySdksTable(sdks, verbose, all, wide)
+	})
 }
 
-func _displaySdks(sdks []xaapiv1.SDK, verbose bool, all bool, filter string) {
+func _displaySdksTable(sdks []xaapiv1.SDK, verbose bool, all bool, wide bool) {
 	// Display result
 	first := true
 	writer := NewTableWriter()
 	for _, s := range sdks {
-		if s.Status != xaapiv1.SdkStatusInstalled && !all {
-			continue
-		}
-		if filter != "" {
-			re := regexp.MustCompile(filter)
-			if !(re.MatchString(s.ID) || re.MatchString(s.Name) ||
-				re.MatchString(s.Profile) || re.MatchString(s.Arch) ||
-				re.MatchString(s.Version)) {
-				continue
-			}
-		}
-
 		if verbose {
 			if !first {
 				fmt.Fprintln(writer)
@@ -189,15 +209,30 @@ func _displaySdks(sdks []xaapiv1.SDK, verbose bool, all bool, filter string) {
 				} else {
 					fmt.Fprintf(writer, "List of installed SDKs: \n")
 				}
-				fmt.Fprintf(writer, "ID\t NAME\t STATUS\t VERSION\t ARCH\n")
+				if wide {
+					fmt.Fprintf(writer, "ID\t NAME\t PROFILE\t STATUS\t VERSION\t ARCH\n")
+				} else {
+					fmt.Fprintf(writer, "ID\t NAME\t STATUS\t VERSION\t ARCH\n")
+				}
+			}
+			if wide {
+				fmt.Fprintf(writer, "%s\t %s\t %s\t %s\t %s\t %s\n", s.ID, s.Name, s.Profile, s.Status, s.Version, s.Arch)
+			} else {
+				fmt.Fprintf(writer, "%s\t %s\t %s\t %s\t %s\n", _shortID(s.ID), s.Name, s.Status, s.Version, s.Arch)
 			}
-			fmt.Fprintf(writer, "%s\t %s\t %s\t %s\t %s\n", s.ID[:8], s.Name, s.Status, s.Version, s.Arch)
 		}
 		first = false
 	}
 	writer.Flush()
 }
 
+// _sdkMatchFilter Return true when ID, Name, Profile, Arch or Version of a SDK matches
+func _sdkMatchFilter(s xaapiv1.SDK, re *regexp.Regexp) bool {
+	return re.MatchString(s.ID) || re.MatchString(s.Name) ||
+		re.MatchString(s.Profile) || re.MatchString(s.Arch) ||
+		re.MatchString(s.Version)
+}
+
 func _sdksListGet(sdks *[]xaapiv1.SDK) error {
 	url := XdsServerComputeURL("/sdks")
 	if err := HTTPCli.Get(url, &sdks); err != nil {
@@ -208,13 +243,38 @@ func _sdksListGet(sdks *[]xaapiv1.SDK) error {
 	return nil
 }
 
+// SdkIDResolve Return ID of a SDK referenced either by its ID, an unique
+// prefix of its ID or its name
+func SdkIDResolve(ref string) (string, error) {
+	sdks := []xaapiv1.SDK{}
+	if err := _sdksListGet(&sdks); err != nil {
+		return "", err
+	}
+	items := []IDItem{}
+	for _, s := range sdks {
+		items = append(items, IDItem{ID: s.ID, Name: s.Name})
+	}
+	return ResolveID("SDK", ref, items)
+}
+
 func sdksInstall(ctx *cli.Context) error {
 	id := GetID(ctx)
 	file := ctx.String("file")
 	force := ctx.Bool("force")
 
+	follow := ctx.Bool("follow")
+
 	if id == "" && file == "" {
-		return cli.NewExitError("id or file parameter or option must be set", 1)
+		return cli.NewExitError("id or file parameter or option must be set", ExitCodeInvalidArgs)
+	}
+	if follow && id == "" {
+		return cli.NewExitError("id parameter or option must be set with --follow option", ExitCodeInvalidArgs)
+	}
+	if id != "" {
+		var err error
+		if id, err = SdkIDResolve(id); err != nil {
+			return ExitErrorHTTP(err)
+		}
 	}
 
 	// Process Socket IO events
@@ -224,73 +284,148 @@ func sdksInstall(ctx *cli.Context) error {
 	}
 	exitChan := make(chan exitResult, 1)
 
-	IOsk.On("disconnection", func(err error) {
+	reconnectTimeout, err := ParseTimeout(ctx.GlobalString("reconnect-timeout"))
+	if err != nil {
+		return cli.NewExitError("--reconnect-timeout: "+err.Error(), ExitCodeInvalidArgs)
+	}
+
+	disconnChan := make(chan error, 1)
+	IOskOn("disconnection", func(err error) {
 		Log.Debugf("WS disconnection event with err: %v\n", err)
-		errMsg := ""
-		if err != nil {
-			errMsg = err.Error()
+		select {
+		case disconnChan <- err:
+		default:
 		}
-		exitChan <- exitResult{errMsg, 2}
 	})
 
-	IOsk.On(xaapiv1.EVTSDKInstall, func(ev xaapiv1.EventMsg) {
+	// Output is held while Ctrl+C menu is displayed
+	out := &sdkInstallOutput{}
+	IOskOn(xaapiv1.EVTSDKInstall, func(ev xaapiv1.EventMsg) {
 		sdkEvt, _ := ev.DecodeSDKMsg()
-
-		if sdkEvt.Stdout != "" {
-			fmt.Printf("%s", sdkEvt.Stdout)
-		}
-		if sdkEvt.Stderr != "" {
-			fmt.Fprintf(os.Stderr, "%s", sdkEvt.Stderr)
+		if follow && sdkEvt.Sdk.ID != "" && sdkEvt.Sdk.ID != id {
+			return
 		}
 
+		out.Write(sdkEvt.Stdout, sdkEvt.Stderr)
+
 		if sdkEvt.Exited {
 			exitChan <- exitResult{sdkEvt.Error, sdkEvt.Code}
 		}
 	})
 
-	evReg := xaapiv1.EventRegisterArgs{Name: xaapiv1.EVTSDKInstall}
-	if err := HTTPCli.Post("/events/register", &evReg, nil); err != nil {
-		return cli.NewExitError(err, 1)
+	if err := IOskEventRegister(xaapiv1.EVTSDKInstall); err != nil {
+		return ExitErrorHTTP(err)
 	}
 
-	url := XdsServerComputeURL("/sdks")
-	sdks := xaapiv1.SDKInstallArgs{
-		ID:       id,
-		Filename: file,
-		Force:    force,
-	}
+	newSdk := xaapiv1.SDK{}
+	if follow {
+		url := XdsServerComputeURL("/sdks/" + id)
+		if err := HTTPCli.Get(url, &newSdk); err != nil {
+			return ExitErrorHTTP(err)
+		}
+		switch newSdk.Status {
+		case xaapiv1.SdkStatusInstalling:
+			fmt.Printf("Following installation of '%s' SDK (output produced before is not displayed).\n", newSdk.Name)
+		case xaapiv1.SdkStatusInstalled:
+			fmt.Println("SDK ID " + newSdk.ID + " successfully installed.")
+			return nil
+		default:
+			return cli.NewExitError(fmt.Sprintf("SDK %s is not being installed (status: %s) %s", newSdk.ID, newSdk.Status, newSdk.LastError), ExitCodeInvalidArgs)
+		}
+	} else {
+		url := XdsServerComputeURL("/sdks")
+		sdks := xaapiv1.SDKInstallArgs{
+			ID:       id,
+			Filename: file,
+			Force:    force,
+		}
 
-	if ctx.Bool("debug") {
-		sdks.InstallArgs = []string{"--debug"}
-	}
+		if ctx.Bool("debug") {
+			sdks.InstallArgs = []string{"--debug"}
+		}
 
-	newSdk := xaapiv1.SDK{}
-	if err := HTTPCli.Post(url, &sdks, &newSdk); err != nil {
-		return cli.NewExitError(err, 1)
+		if err := HTTPCli.Post(url, &sdks, &newSdk); err != nil {
+			return ExitErrorHTTP(err)
+		}
+		Log.Debugf("Result of %s: %v", url, newSdk)
+		fmt.Printf("Installation of '%s' SDK successfully started.\n", newSdk.Name)
 	}
-	Log.Debugf("Result of %s: %v", url, newSdk)
-	fmt.Printf("Installation of '%s' SDK successfully started.\n", newSdk.Name)
 
-	// TODO: trap CTRL+C and print question: "Installation of xxx is in progress, press 'a' to abort, 'b' to continue in background or 'c' to continue installation"
+	// Trap Ctrl+C to let user choose between abort, background or continue
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
 
 	// Wait exit
-	select {
-	case res := <-exitChan:
-		if res.code == 0 {
-			Log.Debugln("Exit successfully")
-			fmt.Println("SDK ID " + newSdk.ID + " successfully installed.")
-		}
-		if res.error != "" {
-			Log.Debugln("Exit with ERROR: ", res.error)
+	for {
+		select {
+		case <-sigs:
+			switch _sdkInstallMenu(newSdk, out, sigs) {
+			case "a":
+				fmt.Fprintf(os.Stderr, "Aborting installation of SDK %s...\n", newSdk.ID)
+				args := xaapiv1.SDKInstallArgs{ID: newSdk.ID}
+				abortSdk := xaapiv1.SDK{}
+				if err := HTTPCli.Post(XdsServerComputeURL("/sdks/abortinstall"), &args, &abortSdk); err != nil {
+					fmt.Fprintf(os.Stderr, "Cannot abort installation\n")
+					return ExitErrorHTTP(err)
+				}
+				select {
+				case <-exitChan:
+				case <-time.After(30 * time.Second):
+				}
+				return cli.NewExitError("SDK installation aborted", ExitCodeInterrupted)
+
+			case "b":
+				fmt.Fprintf(os.Stderr, "Installation continues in background, use '%s sdks install --follow %s' to follow it.\n", AppName, newSdk.ID)
+				return nil
+			}
+
+		case res := <-exitChan:
+			if res.code == 0 {
+				Log.Debugln("Exit successfully")
+				fmt.Println("SDK ID " + newSdk.ID + " successfully installed.")
+			}
+			if res.error != "" {
+				Log.Debugln("Exit with ERROR: ", res.error)
+			}
+			if res.code != 0 {
+				return cli.NewExitError(res.error, ExitCodeRemote)
+			}
+			return cli.NewExitError(res.error, res.code)
+
+		case err := <-disconnChan:
+			if reconnectTimeout == 0 {
+				return cli.NewExitError(fmt.Sprintf("connection to XDS agent lost: %v", err), ExitCodeConnection)
+			}
+			fmt.Fprintf(os.Stderr, "\nWARNING: connection to XDS agent lost, trying to reconnect...\n")
+			if err := IOskReconnect(reconnectTimeout); err != nil {
+				return cli.NewExitError(err.Error(), ExitCodeConnection)
+			}
+			fmt.Fprintf(os.Stderr, "WARNING: connection to XDS agent restored, installation output produced meanwhile is not displayed\n")
+
+			// Installation may have completed while connection was down: its
+			// result is then the one of SDK status
+			sdk := xaapiv1.SDK{}
+			if err := HTTPCli.Get(XdsServerComputeURL("/sdks/"+newSdk.ID), &sdk); err != nil {
+				return ExitErrorHTTP(err)
+			}
+			switch sdk.Status {
+			case xaapiv1.SdkStatusInstalling:
+				// still in progress, continue to follow it
+			case xaapiv1.SdkStatusInstalled:
+				fmt.Println("SDK ID " + newSdk.ID + " successfully installed.")
+				return cli.NewExitError("", 0)
+			default:
+				return cli.NewExitError(sdk.LastError, ExitCodeRemote)
+			}
 		}
-		return cli.NewExitError(res.error, res.code)
 	}
 }
 
 func sdksUnInstall(ctx *cli.Context) error {
-	id := GetID(ctx)
-	if id == "" {
-		return cli.NewExitError("id parameter or option must be set", 1)
+	id, err := GetIDResolved(ctx, SdkIDResolve)
+	if err != nil {
+		return ExitErrorHTTP(err)
 	}
 
 	if !ctx.Bool("force") {
@@ -302,7 +437,7 @@ func sdksUnInstall(ctx *cli.Context) error {
 	delSdk := xaapiv1.SDK{}
 	url := XdsServerComputeURL("/sdks/" + id)
 	if err := HTTPCli.Delete(url, &delSdk); err != nil {
-		return cli.NewExitError(err, 1)
+		return ExitErrorHTTP(err)
 	}
 
 	Log.Debugf("Result of %s: %v", url, delSdk)
@@ -312,18 +447,84 @@ func sdksUnInstall(ctx *cli.Context) error {
 }
 
 func sdksAbort(ctx *cli.Context) error {
-	id := GetID(ctx)
-	if id == "" {
-		return cli.NewExitError("id parameter or option must be set", 1)
+	id, err := GetIDResolved(ctx, SdkIDResolve)
+	if err != nil {
+		return ExitErrorHTTP(err)
 	}
 
 	sdks := xaapiv1.SDKInstallArgs{ID: id}
 	newSdk := xaapiv1.SDK{}
 	url := XdsServerComputeURL("/sdks/abortinstall")
 	if err := HTTPCli.Post(url, &sdks, &newSdk); err != nil {
-		return cli.NewExitError(err, 1)
+		return ExitErrorHTTP(err)
 	}
 
 	Log.Debugf("Result of %s: %v", url, newSdk)
 	return nil
 }
+
+// sdkInstallOutput Output of a SDK installation, held while paused
+type sdkInstallOutput struct {
+	sync.Mutex
+	paused bool
+	stdout string
+	stderr string
+}
+
+// Write Print (or hold when paused) installation output
+func (o *sdkInstallOutput) Write(stdout, stderr string) {
+	o.Lock()
+	defer o.Unlock()
+	if o.paused {
+		o.stdout += stdout
+		o.stderr += stderr
+		return
+	}
+	fmt.Print(stdout)
+	fmt.Fprint(os.Stderr, stderr)
+}
+
+// Pause Hold (or release when false) output
+func (o *sdkInstallOutput) Pause(paused bool) {
+	o.Lock()
+	defer o.Unlock()
+	o.paused = paused
+	if !paused {
+		fmt.Print(o.stdout)
+		fmt.Fprint(os.Stderr, o.stderr)
+		o.stdout, o.stderr = "", ""
+	}
+}
+
+// _sdkInstallMenu Ask user what to do with an installation in progress and
+// return 'a' (abort), 'b' (background) or 'c' (continue); a new Ctrl+C or a
+// non interactive input means background
+func _sdkInstallMenu(sdk xaapiv1.SDK, out *sdkInstallOutput, sigs chan os.Signal) string {
+	if !IsTerminal(os.Stdin) {
+		return "b"
+	}
+	out.Pause(true)
+	defer out.Pause(false)
+
+	answerChan := make(chan string, 1)
+	for {
+		fmt.Fprintf(os.Stderr, "\nInstallation of '%s' is in progress, press 'a' to abort, 'b' to continue in background or 'c' to continue installation: ", sdk.Name)
+		go func() {
+			answerChan <- strings.ToLower(StdinReadLine())
+		}()
+		select {
+		case ans := <-answerChan:
+			switch ans {
+			case "a", "abort":
+				return "a"
+			case "b", "background":
+				return "b"
+			case "c", "continue":
+				return "c"
+			}
+		case <-sigs:
+			fmt.Fprintln(os.Stderr)
+			return "b"
+		}
+	}
+}
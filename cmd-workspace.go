@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/urfave/cli"
+)
+
+// WorkspaceVersion Version of workspace file format
+const WorkspaceVersion = 1
+
+// Workspace Description of a XDS workspace (see workspace export command)
+type Workspace struct {
+	Version    int                     `json:"version"`
+	CliVersion string                  `json:"cliVersion"`
+	Date       time.Time               `json:"date"`
+	Config     xaapiv1.APIConfig       `json:"config"`
+	Projects   []xaapiv1.ProjectConfig `json:"projects"`
+	Sdks       []WorkspaceSdk          `json:"sdks"`
+}
+
+// WorkspaceSdk Identity of an installed SDK
+type WorkspaceSdk struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Profile string `json:"profile"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+}
+
+func initCmdWorkspace(cmdDef *[]cli.Command) {
+	*cmdDef = append(*cmdDef, cli.Command{
+		Name:     "workspace",
+		Aliases:  []string{"ws"},
+		HideHelp: true,
+		Usage:    "workspace commands group (export or import all projects and SDKs)",
+		Subcommands: []cli.Command{
+			{
+				Name:   "export",
+				Usage:  "Export agent config, projects and installed SDKs as json on stdout",
+				Action: workspaceExport,
+			},
+			{
+				Name:      "import",
+				Usage:     "Create projects and install SDKs of an exported workspace when missing",
+				ArgsUsage: "<workspace file> (- for stdin)",
+				Action:    workspaceImport,
+				Flags: []cli.Flag{
+					cli.StringSliceFlag{
+						Name:  "map-path, m",
+						Usage: "translate path prefix of projects (local and server paths, whole path elements only), e.g. /home/alice=/home/bob (can be repeated)",
+					},
+					cli.BoolFlag{
+						Name:  "dry-run, n",
+						Usage: "only display what would be changed",
+					},
+					cli.BoolFlag{
+						Name:  "no-check",
+						Usage: "don't check that server path maps to local path (pathmap projects)",
+					},
+				},
+			},
+		},
+	})
+}
+
+func workspaceExport(ctx *cli.Context) error {
+	ws := Workspace{
+		Version:    WorkspaceVersion,
+		CliVersion: AppVersion,
+		Date:       time.Now().UTC(),
+		Sdks:       []WorkspaceSdk{},
+	}
+	if err := XdsConfigGet(&ws.Config); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	if err := ProjectsListGet(&ws.Projects); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	sdks := []xaapiv1.SDK{}
+	if err := _sdksListGet(&sdks); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	for _, s := range sdks {
+		if s.Status == xaapiv1.SdkStatusInstalled {
+			ws.Sdks = append(ws.Sdks, WorkspaceSdk{ID: s.ID, Name: s.Name, Profile: s.Profile, Version: s.Version, Arch: s.Arch})
+		}
+	}
+
+	data, err := json.MarshalIndent(ws, "", "  ")
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	fmt.Println(string(data))
+	fmt.Fprintf(os.Stderr, "%d project(s) and %d SDK(s) exported.\n", len(ws.Projects), len(ws.Sdks))
+	return nil
+}
+
+func workspaceImport(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return cli.NewExitError("workspace file must be set", ExitCodeInvalidArgs)
+	}
+	mappings := []PathMapping{}
+	for _, m := range ctx.StringSlice("map-path") {
+		pm := strings.SplitN(m, "=", 2)
+		if len(pm) != 2 || pm[0] == "" || pm[1] == "" {
+			return cli.NewExitError("invalid --map-path value '"+m+"' (e.g. /home/alice=/home/bob)", ExitCodeInvalidArgs)
+		}
+		mappings = append(mappings, PathMapping{From: pm[0], To: pm[1]})
+	}
+	dryRun := ctx.Bool("dry-run")
+
+	var data []byte
+	var err error
+	if file := ctx.Args().First(); file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
+	}
+	ws := Workspace{}
+	if err := json.Unmarshal(data, &ws); err != nil {
+		return cli.NewExitError("invalid workspace file: "+err.Error(), ExitCodeInvalidArgs)
+	}
+	if ws.Version > WorkspaceVersion {
+		return cli.NewExitError(fmt.Sprintf("unsupported workspace version %d (%s supports version %d)", ws.Version, AppName, WorkspaceVersion), ExitCodeInvalidArgs)
+	}
+
+	// Get current state
+	cfg := xaapiv1.APIConfig{}
+	if err := XdsConfigGet(&cfg); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	prjs := []xaapiv1.ProjectConfig{}
+	if err := ProjectsListGet(&prjs); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	sdks := []xaapiv1.SDK{}
+	if err := _sdksListGet(&sdks); err != nil {
+		return ExitErrorHTTP(err)
+	}
+	if dryRun {
+		fmt.Println("Dry run, nothing is changed:")
+	}
+
+	// Agent config is not changed, only reported
+	for i, svr := range ws.Config.Servers {
+		if i < len(cfg.Servers) && cfg.Servers[i].URL != svr.URL {
+			fmt.Printf("  ! server url is %s (%s in workspace), agent config is not changed\n", cfg.Servers[i].URL, svr.URL)
+		}
+	}
+
+	nbErr := 0
+	sdkIDs := make(map[string]string) // exported ID -> current ID
+	for _, s := range ws.Sdks {
+		sdk, found := _workspaceSdkFind(s, sdks)
+		if found {
+			sdkIDs[s.ID] = sdk.ID
+		}
+		switch {
+		case !found:
+			fmt.Printf("  ! sdk %s (%s): not available on server\n", s.Name, s.ID)
+			nbErr++
+		case sdk.Status == xaapiv1.SdkStatusInstalled || sdk.Status == xaapiv1.SdkStatusInstalling:
+			fmt.Printf("  = sdk %s (%s): %s\n", sdk.Name, sdk.ID, strings.ToLower(sdk.Status))
+		default:
+			fmt.Printf("  + sdk %s (%s): install\n", sdk.Name, sdk.ID)
+			if dryRun {
+				continue
+			}
+			args := xaapiv1.SDKInstallArgs{ID: sdk.ID}
+			newSdk := xaapiv1.SDK{}
+			if err := HTTPCli.Post(XdsServerComputeURL("/sdks"), &args, &newSdk); err != nil {
+				fmt.Printf("    ERROR: %v\n", err)
+				nbErr++
+				continue
+			}
+			fmt.Printf("    installation started, use '%s sdks install --follow %s' to follow it\n", AppName, sdk.ID)
+		}
+	}
+
+	for _, p := range ws.Projects {
+		prj := xaapiv1.ProjectConfig{
+			ServerID:   XdsServerIDGet(),
+			Label:      p.Label,
+			Type:       p.Type,
+			ClientPath: p.ClientPath,
+			ServerPath: p.ServerPath,
+			DefaultSdk: p.DefaultSdk,
+		}
+		if id, ok := sdkIDs[prj.DefaultSdk]; ok {
+			prj.DefaultSdk = id
+		}
+		prj.ClientPath, _ = PathMapPrefix(filepath.Clean(prj.ClientPath), mappings)
+		if prj.ServerPath != "" {
+			prj.ServerPath, _ = PathMapPrefix(prj.ServerPath, mappings)
+		}
+		desc := fmt.Sprintf("project '%s' (%s) %s", prj.Label, prj.Type, prj.ClientPath)
+
+		if existing := _workspacePrjFind(prj, prjs); existing != nil {
+			fmt.Printf("  = %s: already exists (id %s)\n", desc, existing.ID)
+			continue
+		}
+		if err := _projectAddCheck(&prj, prjs); err != nil {
+			fmt.Printf("  ! %s: %v (see --map-path option)\n", desc, err)
+			nbErr++
+			continue
+		}
+		fmt.Printf("  + %s: create\n", desc)
+		if dryRun {
+			continue
+		}
+		newPrj, err := _projectCreate(prj, prjs, !ctx.Bool("no-check"))
+		if err != nil {
+			fmt.Printf("    ERROR: %v\n", err)
+			nbErr++
+			continue
+		}
+		fmt.Printf("    created with id %s\n", newPrj.ID)
+		prjs = append(prjs, newPrj)
+	}
+
+	if nbErr > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d error(s) while importing workspace", nbErr), ExitCodeError)
+	}
+	return nil
+}
+
+// _workspaceSdkFind Return the SDK matching an exported SDK (by ID, else by name)
+func _workspaceSdkFind(s WorkspaceSdk, sdks []xaapiv1.SDK) (xaapiv1.SDK, bool) {
+	for _, sdk := range sdks {
+		if sdk.ID == s.ID {
+			return sdk, true
+		}
+	}
+	for _, sdk := range sdks {
+		if s.Name != "" && sdk.Name == s.Name {
+			return sdk, true
+		}
+	}
+	return xaapiv1.SDK{}, false
+}
+
+// _workspacePrjFind Return the existing project using the same local path
+func _workspacePrjFind(prj xaapiv1.ProjectConfig, prjs []xaapiv1.ProjectConfig) *xaapiv1.ProjectConfig {
+	for i := range prjs {
+		if filepath.Clean(prjs[i].ClientPath) == prj.ClientPath {
+			return &prjs[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestWorkspaceSdkFind(t *testing.T) {
+	sdks := []xaapiv1.SDK{
+		{ID: "aaa111", Name: "poky-agl-aarch64-4.0.1"},
+		{ID: "bbb222", Name: "poky-agl-x86_64-4.0.1"},
+	}
+	tests := []struct {
+		name   string
+		sdk    WorkspaceSdk
+		wantID string
+	}{
+		{"same id", WorkspaceSdk{ID: "bbb222", Name: "other"}, "bbb222"},
+		{"same name", WorkspaceSdk{ID: "zzz999", Name: "poky-agl-aarch64-4.0.1"}, "aaa111"},
+		{"not installed", WorkspaceSdk{ID: "zzz999", Name: "poky-agl-arm-4.0.1"}, ""},
+		{"no name", WorkspaceSdk{ID: "zzz999"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := _workspaceSdkFind(tt.sdk, sdks)
+			if ok != (tt.wantID != "") || got.ID != tt.wantID {
+				t.Errorf("_workspaceSdkFind() = %q, %v, want %q", got.ID, ok, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestWorkspacePrjFind(t *testing.T) {
+	prjs := []xaapiv1.ProjectConfig{
+		{ID: "p1", ClientPath: "/home/bob/projects/app/"},
+		{ID: "p2", ClientPath: "/home/bob/projects/app2"},
+	}
+	mappings := []PathMapping{{From: "/home/alice", To: "/home/bob"}}
+	tests := []struct {
+		name       string
+		clientPath string
+		wantID     string
+	}{
+		{"mapped path of existing project", "/home/alice/projects/app", "p1"},
+		{"mapped path with trailing slash", "/home/alice/projects/app2/", "p2"},
+		{"path already local", "/home/bob/projects/app2", "p2"},
+		{"prefix on element boundary only", "/home/alice2/projects/app", ""},
+		{"new project", "/home/alice/projects/app3", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// same translation as workspace import
+			clientPath, _ := PathMapPrefix(filepath.Clean(tt.clientPath), mappings)
+			got := _workspacePrjFind(xaapiv1.ProjectConfig{ClientPath: clientPath}, prjs)
+			gotID := ""
+			if got != nil {
+				gotID = got.ID
+			}
+			if gotID != tt.wantID {
+				t.Errorf("_workspacePrjFind(%s) = %q, want %q", clientPath, gotID, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Diagnostic A warning or an error reported by a build tool
+type Diagnostic struct {
+	Tool     string `json:"tool"`
+	Severity string `json:"severity"` // error, warning or note
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+// DiagCollector Parse diagnostics of gcc, clang, ld, cmake and make from
+// command output streams
+type DiagCollector struct {
+	sync.Mutex
+	srcRoot  string
+	rPath    string
+	mappings []PathMapping
+	pending  map[string]string
+	known    map[string]bool
+	Diags    []Diagnostic
+}
+
+var (
+	// file:line[:col]: [fatal ]error|warning|note: message
+	diagCompilerRe = regexp.MustCompile(`^([^\s:][^:]*):(\d+):(?:(\d+):)?\s+(fatal error|error|warning|note):\s+(.*)$`)
+	// CMake Error|Warning [(dev)] at file:line [(command)]:
+	diagCMakeRe = regexp.MustCompile(`^CMake (Error|Warning)(?: \(dev\))? at ([^:]+):(\d+)`)
+	// make[N]: *** message
+	diagMakeRe = regexp.MustCompile(`^g?make(?:\[\d+\])?: \*\*\* (.*)$`)
+	// [/path/]ld[.bfd|.gold]: [warning: ]message
+	diagLdRe = regexp.MustCompile(`^(?:\S*/)?(?:[\w.-]+-)?ld(?:\.\w+)?: (warning: )?(.*)$`)
+	// file.o:(section+0x..): undefined reference to ...
+	diagLdRefRe = regexp.MustCompile(`^([^\s:]+):(?:\([^)]*\)|[^:]*:\d+): ((?:undefined|multiple) .*)$`)
+)
+
+// NewDiagCollector Create a diagnostics collector, file paths are translated
+// using mappings and then made relative to srcRoot when possible; relative
+// paths are the ones of a command executed in rPath directory of srcRoot
+func NewDiagCollector(srcRoot, rPath string, mappings []PathMapping) *DiagCollector {
+	return &DiagCollector{
+		srcRoot:  srcRoot,
+		rPath:    rPath,
+		mappings: mappings,
+		pending:  make(map[string]string),
+		known:    make(map[string]bool),
+	}
+}
+
+// Feed Parse a chunk of a stream (identified by key) line by line
+func (c *DiagCollector) Feed(key, data string) {
+	if c == nil || data == "" {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	data = c.pending[key] + data
+	lines := strings.Split(data, "\n")
+	c.pending[key] = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		c.parseLine(strings.TrimRight(line, "\r"))
+	}
+}
+
+// Close Parse last incomplete lines
+func (c *DiagCollector) Close() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for key, line := range c.pending {
+		c.parseLine(line)
+		delete(c.pending, key)
+	}
+}
+
+func (c *DiagCollector) parseLine(line string) {
+	var d *Diagnostic
+	if m := diagCompilerRe.FindStringSubmatch(line); m != nil {
+		sev := m[4]
+		if sev == "fatal error" {
+			sev = "error"
+		}
+		l, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		d = &Diagnostic{Tool: "compiler", Severity: sev, File: m[1], Line: l, Column: col, Message: m[5]}
+	} else if m := diagCMakeRe.FindStringSubmatch(line); m != nil {
+		l, _ := strconv.Atoi(m[3])
+		d = &Diagnostic{Tool: "cmake", Severity: strings.ToLower(m[1]), File: m[2], Line: l, Message: line}
+	} else if m := diagMakeRe.FindStringSubmatch(line); m != nil {
+		d = &Diagnostic{Tool: "make", Severity: "error", Message: m[1]}
+	} else if m := diagLdRe.FindStringSubmatch(line); m != nil {
+		sev := "error"
+		if m[1] != "" {
+			sev = "warning"
+		}
+		d = &Diagnostic{Tool: "ld", Severity: sev, Message: m[2]}
+	} else if m := diagLdRefRe.FindStringSubmatch(line); m != nil {
+		d = &Diagnostic{Tool: "ld", Severity: "error", File: m[1], Message: m[2]}
+	}
+	if d == nil {
+		return
+	}
+
+	d.File = c.mapPath(d.File)
+	key := fmt.Sprintf("%s:%d:%d:%s:%s", d.File, d.Line, d.Column, d.Severity, d.Message)
+	if c.known[key] {
+		return
+	}
+	c.known[key] = true
+	c.Diags = append(c.Diags, *d)
+}
+
+func (c *DiagCollector) mapPath(file string) string {
+	if file == "" {
+		return ""
+	}
+	if !strings.HasPrefix(file, "/") && !filepath.IsAbs(file) {
+		// Relative to directory where command has been executed
+		rel := path.Join(c.rPath, filepath.ToSlash(file))
+		if c.srcRoot != "" && (rel == ".." || strings.HasPrefix(rel, "../")) {
+			return filepath.Join(c.srcRoot, filepath.FromSlash(rel))
+		}
+		return rel
+	}
+	for _, m := range c.mappings {
+		from := strings.TrimRight(m.From, "/")
+		if from != "" && (file == from || strings.HasPrefix(file, from+"/")) {
+			file = strings.TrimRight(m.To, "/") + file[len(from):]
+			break
+		}
+	}
+	if c.srcRoot != "" && filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(c.srcRoot, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return file
+}
+
+// Count Return number of errors and warnings
+func (c *DiagCollector) Count() (int, int) {
+	nbErr, nbWarn := 0, 0
+	for _, d := range c.Diags {
+		switch d.Severity {
+		case "error":
+			nbErr++
+		case "warning":
+			nbWarn++
+		}
+	}
+	return nbErr, nbWarn
+}
+
+// PrintSummary Print number of errors and warnings and files having most diagnostics
+func (c *DiagCollector) PrintSummary(w io.Writer, nbTopFiles int) {
+	nbErr, nbWarn := c.Count()
+	fmt.Fprintf(w, "\nDiagnostics: %d error(s), %d warning(s)\n", nbErr, nbWarn)
+
+	perFile := make(map[string]int)
+	for _, d := range c.Diags {
+		if d.File != "" && d.Severity != "note" {
+			perFile[d.File]++
+		}
+	}
+	files := make([]string, 0, len(perFile))
+	for f := range perFile {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if perFile[files[i]] != perFile[files[j]] {
+			return perFile[files[i]] > perFile[files[j]]
+		}
+		return files[i] < files[j]
+	})
+	if len(files) > nbTopFiles {
+		files = files[:nbTopFiles]
+	}
+	for _, f := range files {
+		fmt.Fprintf(w, "  %4d  %s\n", perFile[f], f)
+	}
+}
+
+// WriteReport Write diagnostics into a file using either sarif or json format
+func (c *DiagCollector) WriteReport(file, format string) error {
+	var report interface{}
+	switch format {
+	case "json":
+		nbErr, nbWarn := c.Count()
+		report = map[string]interface{}{
+			"srcRoot":     c.srcRoot,
+			"errors":      nbErr,
+			"warnings":    nbWarn,
+			"diagnostics": c.Diags,
+		}
+	case "sarif":
+		report = c.sarifReport()
+	default:
+		return fmt.Errorf("unsupported diagnostics format '%s' (sarif or json)", format)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// sarifReport Build a SARIF 2.1.0 log
+func (c *DiagCollector) sarifReport() map[string]interface{} {
+	results := []interface{}{}
+	for _, d := range c.Diags {
+		res := map[string]interface{}{
+			"ruleId":  d.Tool,
+			"level":   d.Severity,
+			"message": map[string]string{"text": d.Message},
+		}
+		if d.File != "" {
+			artifact := map[string]string{"uri": d.File}
+			if !filepath.IsAbs(d.File) && c.srcRoot != "" {
+				artifact["uriBaseId"] = "SRCROOT"
+			}
+			loc := map[string]interface{}{"artifactLocation": artifact}
+			if d.Line > 0 {
+				region := map[string]int{"startLine": d.Line}
+				if d.Column > 0 {
+					region["startColumn"] = d.Column
+				}
+				loc["region"] = region
+			}
+			res["locations"] = []interface{}{map[string]interface{}{"physicalLocation": loc}}
+		}
+		results = append(results, res)
+	}
+
+	run := map[string]interface{}{
+		"tool": map[string]interface{}{
+			"driver": map[string]string{
+				"name":    AppName,
+				"version": AppVersion,
+			},
+		},
+		"results": results,
+	}
+	if c.srcRoot != "" {
+		run["originalUriBaseIds"] = map[string]interface{}{
+			"SRCROOT": map[string]string{"uri": "file://" + filepath.ToSlash(c.srcRoot) + "/"},
+		}
+	}
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs":    []interface{}{run},
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiagCollectorParse(t *testing.T) {
+	mappings := []PathMapping{{From: "/xds/prj", To: "/home/me/prj"}}
+	tests := []struct {
+		rpath string
+		line  string
+		want  []Diagnostic
+	}{
+		{"", "/xds/prj/src/main.c:12:5: warning: unused variable 'x'",
+			[]Diagnostic{{Tool: "compiler", Severity: "warning", File: "src/main.c", Line: 12, Column: 5, Message: "unused variable 'x'"}}},
+		{"", "src/util.c:3:10: fatal error: foo.h: No such file or directory",
+			[]Diagnostic{{Tool: "compiler", Severity: "error", File: "src/util.c", Line: 3, Column: 10, Message: "foo.h: No such file or directory"}}},
+		{"", "/usr/include/stdio.h:27: note: declared here",
+			[]Diagnostic{{Tool: "compiler", Severity: "note", File: "/usr/include/stdio.h", Line: 27, Message: "declared here"}}},
+		{"", "/xds/prjx/a.c:1:1: error: boom",
+			[]Diagnostic{{Tool: "compiler", Severity: "error", File: "/xds/prjx/a.c", Line: 1, Column: 1, Message: "boom"}}},
+		{"", "CMake Warning (dev) at CMakeLists.txt:5 (project):",
+			[]Diagnostic{{Tool: "cmake", Severity: "warning", File: "CMakeLists.txt", Line: 5, Message: "CMake Warning (dev) at CMakeLists.txt:5 (project):"}}},
+		{"", "CMake Error at /xds/prj/CMakeLists.txt:8 (find_package):",
+			[]Diagnostic{{Tool: "cmake", Severity: "error", File: "CMakeLists.txt", Line: 8, Message: "CMake Error at /xds/prj/CMakeLists.txt:8 (find_package):"}}},
+		{"", "make[2]: *** [Makefile:10: all] Error 1",
+			[]Diagnostic{{Tool: "make", Severity: "error", Message: "[Makefile:10: all] Error 1"}}},
+		{"", "/usr/bin/ld: cannot find -lfoo",
+			[]Diagnostic{{Tool: "ld", Severity: "error", Message: "cannot find -lfoo"}}},
+		{"", "aarch64-linux-gnu-ld.gold: warning: creating a DT_TEXTREL",
+			[]Diagnostic{{Tool: "ld", Severity: "warning", Message: "creating a DT_TEXTREL"}}},
+		{"", "main.o:(.text+0x10): undefined reference to `foo'",
+			[]Diagnostic{{Tool: "ld", Severity: "error", File: "main.o", Message: "undefined reference to `foo'"}}},
+		{"build", "../src/main.c:12:5: error: 'x' undeclared",
+			[]Diagnostic{{Tool: "compiler", Severity: "error", File: "src/main.c", Line: 12, Column: 5, Message: "'x' undeclared"}}},
+		{"app/", "src/main.c:7:1: warning: no newline",
+			[]Diagnostic{{Tool: "compiler", Severity: "warning", File: "app/src/main.c", Line: 7, Column: 1, Message: "no newline"}}},
+		{"app", "/xds/prj/lib/a.c:1:2: error: boom",
+			[]Diagnostic{{Tool: "compiler", Severity: "error", File: "lib/a.c", Line: 1, Column: 2, Message: "boom"}}},
+		{"app", "../../other/b.c:1:2: error: boom",
+			[]Diagnostic{{Tool: "compiler", Severity: "error", File: "/home/me/other/b.c", Line: 1, Column: 2, Message: "boom"}}},
+		{"app", "main.o:(.text+0x10): undefined reference to `foo'",
+			[]Diagnostic{{Tool: "ld", Severity: "error", File: "app/main.o", Message: "undefined reference to `foo'"}}},
+		{"", "gcc -Wall -c src/main.c -o main.o", nil},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		c := NewDiagCollector("/home/me/prj", tt.rpath, mappings)
+		c.Feed("k", tt.line+"\n")
+		c.Close()
+		if !reflect.DeepEqual(c.Diags, tt.want) {
+			t.Errorf("parse %q (rpath %q) = %+v, want %+v", tt.line, tt.rpath, c.Diags, tt.want)
+		}
+	}
+}
+
+func TestDiagCollectorFeed(t *testing.T) {
+	c := NewDiagCollector("", "", nil)
+	// Lines split across chunks and streams, CRLF endings and duplicates
+	c.Feed("out", "a.c:1:2: war")
+	c.Feed("err", "b.c:3:4: error: bad\r\n")
+	c.Feed("out", "ning: w1\na.c:1:2: warning: w1\n")
+	c.Feed("err", "make: *** [all] Error 2")
+	c.Close()
+
+	want := []Diagnostic{
+		{Tool: "compiler", Severity: "error", File: "b.c", Line: 3, Column: 4, Message: "bad"},
+		{Tool: "compiler", Severity: "warning", File: "a.c", Line: 1, Column: 2, Message: "w1"},
+		{Tool: "make", Severity: "error", Message: "[all] Error 2"},
+	}
+	if !reflect.DeepEqual(c.Diags, want) {
+		t.Fatalf("Diags = %+v, want %+v", c.Diags, want)
+	}
+	if nbErr, nbWarn := c.Count(); nbErr != 2 || nbWarn != 1 {
+		t.Errorf("Count() = %d, %d, want 2, 1", nbErr, nbWarn)
+	}
+
+	// A nil collector (diagnostics disabled) ignores output
+	var nc *DiagCollector
+	nc.Feed("out", "a.c:1:2: error: x\n")
+	nc.Close()
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// Exit codes of xds-cli, any change of these values breaks user scripts.
+// Note that a failed remote command (exec, run, jobs wait) exits with its own
+// exit code, see RemoteExitError.
+const (
+	ExitCodeOK          = 0
+	ExitCodeError       = 1   // unclassified error
+	ExitCodeInvalidArgs = 64  // invalid arguments or options
+	ExitCodeNotFound    = 66  // project, SDK, job, recipe... not found
+	ExitCodeConnection  = 69  // XDS agent or server unreachable, connection lost
+	ExitCodeRemote      = 70  // remote operation failed (e.g. SDK install)
+	ExitCodeAuth        = 77  // authentication or permission denied
+	ExitCodeTimeout     = 124 // timeout expired
+	ExitCodeInterrupted = 130 // interrupted by a signal (e.g. Ctrl+C)
+)
+
+// exitCodeKinds Names of exit codes used by json error format
+var exitCodeKinds = map[int]string{
+	ExitCodeError:       "error",
+	ExitCodeInvalidArgs: "invalid_args",
+	ExitCodeNotFound:    "not_found",
+	ExitCodeConnection:  "connection",
+	ExitCodeRemote:      "remote_failure",
+	ExitCodeAuth:        "auth",
+	ExitCodeTimeout:     "timeout",
+	ExitCodeInterrupted: "interrupted",
+}
+
+// errorKindRemoteCommand Kind of errors of failed remote commands
+const errorKindRemoteCommand = "remote_command"
+
+// ExitCodesHelp Description of exit codes displayed by help
+const ExitCodesHelp = `
+EXIT CODES:
+   0     success
+   1     unclassified error (e.g. local file error, fetch of files failed)
+   64    invalid arguments or options
+   66    project, SDK, job or recipe not found
+   69    XDS agent or server unreachable, or connection lost
+   70    remote operation failed (e.g. SDK install)
+   77    authentication or permission denied (HTTP status 401 or 403)
+   124   timeout expired (e.g. project sync or command inactivity)
+   130   interrupted by a signal
+   other exit code of a failed remote command (exec, run, jobs wait)
+
+   With --error-format json, errors are printed on stderr as a json object:
+     {"code": 66, "kind": "not_found", "command": "projects get", "message": "..."}
+   kind being one of: error, invalid_args, remote_command, not_found, connection,
+   remote_failure, auth, timeout, interrupted or remote_command. Errors of remote
+   commands also hold exit code of remote command:
+     {"code": 2, "kind": "remote_command", "remoteCode": 2, "command": "exec", ...}`
+
+// ErrorFormat Format used to print errors: text (default) or json
+var ErrorFormat = "text"
+
+// RemoteExitError Error of a remote command exited with RemoteCode, which is
+// also the exit code
+type RemoteExitError struct {
+	*cli.ExitError
+	RemoteCode int
+}
+
+// NewRemoteExitError Create an error reporting exit code of a remote command
+func NewRemoteExitError(msg string, remoteCode int) error {
+	if remoteCode == 0 {
+		return &RemoteExitError{cli.NewExitError(msg, ExitCodeOK), 0}
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("remote command exited with code %d", remoteCode)
+	} else {
+		msg = fmt.Sprintf("remote command exited with code %d: %s", remoteCode, msg)
+	}
+	return &RemoteExitError{cli.NewExitError(msg, remoteCode), remoteCode}
+}
+
+// ExitErrorFrom Return err unchanged when it already holds an exit code,
+// otherwise an exit error using code
+func ExitErrorFrom(err error, code int) error {
+	if _, ok := err.(cli.ExitCoder); ok {
+		return err
+	}
+	return cli.NewExitError(err.Error(), code)
+}
+
+// httpStatusExitCodes Exit codes of XDS agent responses having these status
+var httpStatusExitCodes = map[int]int{
+	http.StatusUnauthorized: ExitCodeAuth,
+	http.StatusForbidden:    ExitCodeAuth,
+	http.StatusNotFound:     ExitCodeNotFound,
+}
+
+// ExitErrorHTTP Return err unchanged when it already holds an exit code,
+// otherwise the exit error of a failed request to XDS agent: either a
+// connection error or an error returned by XDS agent or server
+func ExitErrorHTTP(err error) error {
+	if _, ok := err.(cli.ExitCoder); ok {
+		return err
+	}
+	if _, ok := err.(net.Error); ok {
+		return cli.NewExitError(err.Error(), ExitCodeConnection)
+	}
+	return cli.NewExitError(err.Error(), httpErrorExitCode(err.Error()))
+}
+
+// httpErrorExitCode Return exit code of an error returned by HTTP client:
+// HTTP status is only known from error message (e.g. "HTTP status 404 Not Found")
+func httpErrorExitCode(msg string) int {
+	for status, code := range httpStatusExitCodes {
+		if strings.Contains(msg, fmt.Sprintf("%d %s", status, http.StatusText(status))) {
+			return code
+		}
+	}
+	return ExitCodeRemote
+}
+
+// ExitErrorHandle Classify an error returned by a command and print it using
+// ErrorFormat; returned error only holds exit code when error has been printed
+func ExitErrorHandle(err error, command string) error {
+	if err == nil {
+		return nil
+	}
+
+	code := ExitCodeError
+	if exitErr, ok := err.(cli.ExitCoder); ok {
+		code = exitErr.ExitCode()
+		if code == ExitCodeOK {
+			return err
+		}
+	}
+	kind := exitCodeKinds[code]
+	if kind == "" {
+		kind = "error"
+	}
+	remoteCode := 0
+	if remoteErr, ok := err.(*RemoteExitError); ok {
+		kind = errorKindRemoteCommand
+		remoteCode = remoteErr.RemoteCode
+	}
+
+	if ErrorFormat != "json" {
+		return cli.NewExitError(err.Error(), code)
+	}
+
+	errPrint(code, kind, command, err.Error(), remoteCode)
+	return cli.NewExitError("", code)
+}
+
+// errPrint Print an error on stderr (IOW cli.ErrWriter) using ErrorFormat
+func errPrint(code int, kind, command, msg string, remoteCode int) {
+	if ErrorFormat != "json" {
+		fmt.Fprintln(cli.ErrWriter, msg)
+		return
+	}
+	data, _ := json.Marshal(struct {
+		Code       int    `json:"code"`
+		Kind       string `json:"kind"`
+		RemoteCode int    `json:"remoteCode,omitempty"`
+		Command    string `json:"command,omitempty"`
+		Message    string `json:"message"`
+	}{code, kind, remoteCode, command, msg})
+	fmt.Fprintln(cli.ErrWriter, string(data))
+}
+
+// actionWrap Wrap a command action in order to handle its returned error
+// (see ExitErrorHandle)
+func actionWrap(action interface{}, command string) interface{} {
+	f, ok := action.(func(*cli.Context) error)
+	if !ok {
+		return action
+	}
+	return func(ctx *cli.Context) error {
+		return ExitErrorHandle(f(ctx), command)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestExitErrorHTTP(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{cli.NewExitError("x", ExitCodeTimeout), ExitCodeTimeout},
+		{&net.DNSError{Err: "no such host", Name: "xds"}, ExitCodeConnection},
+		{errors.New("HTTP status 401 Unauthorized"), ExitCodeAuth},
+		{errors.New("HTTP status 403 Forbidden"), ExitCodeAuth},
+		{errors.New("HTTP status 404 Not Found"), ExitCodeNotFound},
+		{errors.New("HTTP status 500 Internal Server Error"), ExitCodeRemote},
+		{errors.New("invalid project ID 404"), ExitCodeRemote},
+	}
+	for _, tt := range tests {
+		got := ExitErrorHTTP(tt.err).(cli.ExitCoder).ExitCode()
+		if got != tt.want {
+			t.Errorf("ExitErrorHTTP(%v) exit code = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRemoteExitError(t *testing.T) {
+	tests := []struct {
+		msg        string
+		remoteCode int
+		wantMsg    string
+	}{
+		{"", 0, ""},
+		{"", 2, "remote command exited with code 2"},
+		{"killed", 137, "remote command exited with code 137: killed"},
+	}
+	for _, tt := range tests {
+		err := NewRemoteExitError(tt.msg, tt.remoteCode)
+		if code := err.(cli.ExitCoder).ExitCode(); code != tt.remoteCode {
+			t.Errorf("NewRemoteExitError(%q, %d) exit code = %d", tt.msg, tt.remoteCode, code)
+		}
+		if err.Error() != tt.wantMsg {
+			t.Errorf("NewRemoteExitError(%q, %d) = %q, want %q", tt.msg, tt.remoteCode, err.Error(), tt.wantMsg)
+		}
+	}
+}
+
+func TestExitErrorHandleJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	oldW, oldFmt := cli.ErrWriter, ErrorFormat
+	cli.ErrWriter, ErrorFormat = buf, "json"
+	defer func() { cli.ErrWriter, ErrorFormat = oldW, oldFmt }()
+
+	tests := []struct {
+		err        error
+		code       int
+		kind       string
+		remoteCode int
+	}{
+		{NewRemoteExitError("", 66), 66, "remote_command", 66},
+		{cli.NewExitError("unknown project 'x'", ExitCodeNotFound), ExitCodeNotFound, "not_found", 0},
+		{errors.New("boom"), ExitCodeError, "error", 0},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		err := ExitErrorHandle(tt.err, "exec")
+		if code := err.(cli.ExitCoder).ExitCode(); code != tt.code {
+			t.Errorf("ExitErrorHandle(%v) exit code = %d, want %d", tt.err, code, tt.code)
+		}
+		got := struct {
+			Code       int    `json:"code"`
+			Kind       string `json:"kind"`
+			RemoteCode int    `json:"remoteCode"`
+			Command    string `json:"command"`
+			Message    string `json:"message"`
+		}{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("invalid json error %q: %v", buf.String(), err)
+		}
+		if got.Code != tt.code || got.Kind != tt.kind || got.RemoteCode != tt.remoteCode || got.Command != "exec" || got.Message != tt.err.Error() {
+			t.Errorf("ExitErrorHandle(%v) printed %+v", tt.err, got)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/urfave/cli"
+)
+
+// PrefixWriter Writer that prefixes each line, complete lines of all writers
+// sharing the same lock are never mixed up
+type PrefixWriter struct {
+	w      io.Writer
+	prefix string
+	lock   *sync.Mutex
+	buf    []byte
+}
+
+// NewPrefixWriter Create a new line prefixing writer
+func NewPrefixWriter(w io.Writer, prefix string, lock *sync.Mutex) *PrefixWriter {
+	return &PrefixWriter{w: w, prefix: prefix, lock: lock}
+}
+
+func (p *PrefixWriter) Write(data []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.buf = append(p.buf, data...)
+	for {
+		idx := bytes.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(p.w, "%s%s", p.prefix, p.buf[:idx+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[idx+1:]
+	}
+	return len(data), nil
+}
+
+// Flush Write last incomplete line
+func (p *PrefixWriter) Flush() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.buf) > 0 {
+		fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf)
+		p.buf = nil
+	}
+}
+
+// execMatrixSdks returns SDKs set by --sdk-matrix and --sdk-filter options
+func execMatrixSdks(ctx *cli.Context) ([]xaapiv1.SDK, error) {
+	sdks := []xaapiv1.SDK{}
+	known := make(map[string]bool)
+
+	for _, ids := range ctx.StringSlice("sdk-matrix") {
+		for _, id := range strings.Split(ids, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" || known[id] {
+				continue
+			}
+			sdk := xaapiv1.SDK{}
+			id, err := SdkIDResolve(id)
+			if err != nil {
+				return nil, err
+			}
+			if err := HTTPCli.Get(XdsServerComputeURL("/sdks/"+id), &sdk); err != nil {
+				return nil, err
+			}
+			known[sdk.ID] = true
+			sdks = append(sdks, sdk)
+		}
+	}
+
+	if filter := ctx.String("sdk-filter"); filter != "" {
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			return nil, cli.NewExitError(fmt.Sprintf("invalid --sdk-filter regexp: %v", err), ExitCodeInvalidArgs)
+		}
+		all := []xaapiv1.SDK{}
+		if err := _sdksListGet(&all); err != nil {
+			return nil, err
+		}
+		for _, s := range all {
+			if s.Status == xaapiv1.SdkStatusInstalled && !known[s.ID] && _sdkMatchFilter(s, re) {
+				known[s.ID] = true
+				sdks = append(sdks, s)
+			}
+		}
+	}
+
+	if len(sdks) == 0 {
+		return nil, cli.NewExitError("no SDK matches --sdk-matrix / --sdk-filter options", ExitCodeNotFound)
+	}
+	return sdks, nil
+}
+
+// execMatrix runs the same command once per SDK, with a limited number of
+// commands running in parallel, and then displays a summary
+func execMatrix(ctx *cli.Context, prj xaapiv1.ProjectConfig, args xaapiv1.ExecArgs, sigs chan os.Signal, inactivityTimeout time.Duration) error {
+	sdks, err := execMatrixSdks(ctx)
+	if err != nil {
+		return ExitErrorHTTP(err)
+	}
+
+	maxJobs := ctx.Int("matrix-jobs")
+	if maxJobs <= 0 || maxJobs > len(sdks) {
+		maxJobs = len(sdks)
+	}
+
+	type matrixResult struct {
+		started  bool
+		res      ExecExitResult
+		duration time.Duration
+	}
+	results := make([]matrixResult, len(sdks))
+	diags := execDiagCollectorNew(ctx, prj)
+
+	go execSignalForward(sigs)
+
+	var outLock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan bool, maxJobs)
+	for i, sdk := range sdks {
+		sem <- true
+		if atomic.LoadInt32(&execInterrupted) != 0 {
+			<-sem
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, sdk xaapiv1.SDK) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			label := sdk.Name
+			if label == "" {
+				label = _shortID(sdk.ID)
+			}
+			outW := NewPrefixWriter(os.Stdout, "["+label+"] ", &outLock)
+			errW := NewPrefixWriter(os.Stderr, "["+label+"] ", &outLock)
+			defer outW.Flush()
+			defer errW.Flush()
+
+			sdkArgs := args
+			sdkArgs.SdkID = sdk.ID
+			r := execRunnerNew(ctx, prj, sdkArgs, outW, errW)
+			r.Diags = diags
+
+			start := time.Now()
+			results[i].started = true
+			if err := r.Start(); err != nil {
+				fmt.Fprintf(errW, "ERROR: %v\n", err)
+				exitErr := ExitErrorHTTP(err)
+				results[i].res = ExecExitResult{exitErr, exitErr.(cli.ExitCoder).ExitCode()}
+				return
+			}
+			results[i].res = r.Wait(inactivityTimeout)
+			results[i].duration = time.Since(start)
+		}(i, sdk)
+	}
+	wg.Wait()
+	execPendingClear()
+
+	// Display summary (exit code is the one of first failed command)
+	var exitErr error
+	fmt.Println()
+	writer := NewTableWriter()
+	fmt.Fprintln(writer, "SDK ID\t NAME\t EXIT CODE\t DURATION")
+	for i, sdk := range sdks {
+		code, duration := "-", "-"
+		res := results[i]
+		if res.started {
+			code = fmt.Sprintf("%d", res.res.Code)
+			duration = (res.duration / time.Second * time.Second).String()
+			if res.res.Code != 0 && exitErr == nil {
+				exitErr = execExitError(res.res)
+			}
+		} else if exitErr == nil {
+			exitErr = cli.NewExitError("interrupted", ExitCodeInterrupted)
+		}
+		fmt.Fprintln(writer, sdk.ID, "\t", sdk.Name, "\t", code, "\t", duration)
+	}
+	writer.Flush()
+
+	if err := execDiagReport(ctx, diags, os.Stderr); err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	if exitErr == nil {
+		return cli.NewExitError("", 0)
+	}
+	return exitErr
+}
@@ -0,0 +1,321 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/urfave/cli"
+)
+
+// ExecExitResult Result of a remote command (Error is a cli.ExitCoder when
+// result is not the one of remote command, e.g. connection lost)
+type ExecExitResult struct {
+	Error error
+	Code  int
+}
+
+// ExecRunner Execution of one command on XDS server. Several runners can be
+// started at the same time: received events are dispatched using command ID.
+type ExecRunner struct {
+	Args          xaapiv1.ExecArgs
+	CmdID         string
+	OutW          io.Writer
+	ErrW          io.Writer
+	StdoutRw      *PathRewriter
+	StderrRw      *PathRewriter
+	WithTimestamp bool
+	Diags         *DiagCollector
+
+	exitChan     chan ExecExitResult
+	activityChan chan bool
+	outLock      sync.Mutex
+	flushTimer   *time.Timer
+}
+
+var (
+	execRunnersLock  sync.Mutex
+	execRunners      = make(map[string]*ExecRunner)
+	execPendingOut   = make(map[string][]xaapiv1.ExecOutMsg)
+	execPendingExit  = make(map[string]xaapiv1.ExecExitMsg)
+	execEventsOnce   sync.Once
+	execEventsErr    error
+	execReconnecting int32
+)
+
+// NewExecRunner Create a new runner of a remote command
+func NewExecRunner(args xaapiv1.ExecArgs, outW, errW io.Writer) *ExecRunner {
+	return &ExecRunner{
+		Args:         args,
+		OutW:         outW,
+		ErrW:         errW,
+		exitChan:     make(chan ExecExitResult, 1),
+		activityChan: make(chan bool, 1),
+	}
+}
+
+// execEventsInit Register (only once) handlers of exec events and of websocket
+// disconnection (connection is re-established during reconnectTimeout)
+func execEventsInit(reconnectTimeout time.Duration, errW io.Writer) error {
+	execEventsOnce.Do(func() {
+		IOskOn("disconnection", func(err error) {
+			Log.Debugf("WS disconnection event with err: %v\n", err)
+			go execReconnect(err, reconnectTimeout, errW)
+		})
+
+		IOskOn(xaapiv1.ExecOutEvent, func(ev xaapiv1.ExecOutMsg) {
+			if r := execRunnerGet(ev.CmdID); r != nil {
+				r.output(ev.Timestamp, ev.Stdout, ev.Stderr)
+				return
+			}
+			// Command not started yet from our point of view (IOW response
+			// of POST /exec not yet received)
+			execRunnersLock.Lock()
+			execPendingOut[ev.CmdID] = append(execPendingOut[ev.CmdID], ev)
+			execRunnersLock.Unlock()
+		})
+
+		IOskOn(xaapiv1.ExecExitEvent, func(ev xaapiv1.ExecExitMsg) {
+			if r := execRunnerGet(ev.CmdID); r != nil {
+				r.exit(ExecExitResult{ev.Error, ev.Code})
+				return
+			}
+			execRunnersLock.Lock()
+			execPendingExit[ev.CmdID] = ev
+			execRunnersLock.Unlock()
+		})
+
+		execEventsErr = projectEventsInit()
+	})
+	return execEventsErr
+}
+
+// execRunnerGet Return runner of a command ID (an empty ID, sent by old
+// agents, matches the only running command if any)
+func execRunnerGet(cmdID string) *ExecRunner {
+	execRunnersLock.Lock()
+	defer execRunnersLock.Unlock()
+	if r, ok := execRunners[cmdID]; ok {
+		return r
+	}
+	if cmdID == "" && len(execRunners) == 1 {
+		for _, r := range execRunners {
+			return r
+		}
+	}
+	return nil
+}
+
+// execRunnersList Return all running commands
+func execRunnersList() []*ExecRunner {
+	execRunnersLock.Lock()
+	defer execRunnersLock.Unlock()
+	list := []*ExecRunner{}
+	for _, r := range execRunners {
+		list = append(list, r)
+	}
+	return list
+}
+
+// execReconnect Re-establish connection while keeping runners registered:
+// new connection uses the same XDS agent session, so next output and exit
+// events of running commands are still received
+func execReconnect(err error, reconnectTimeout time.Duration, errW io.Writer) {
+	if !atomic.CompareAndSwapInt32(&execReconnecting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&execReconnecting, 0)
+
+	if reconnectTimeout == 0 {
+		execRunnersFail(fmt.Sprintf("connection to XDS agent lost: %v", err))
+		return
+	}
+	fmt.Fprintf(errW, "\nWARNING: connection to XDS agent lost, trying to reconnect...\n")
+	if err := IOskReconnect(reconnectTimeout); err != nil {
+		execRunnersFail(err.Error())
+		return
+	}
+	fmt.Fprintf(errW, "WARNING: connection to XDS agent restored, output produced while disconnected may be lost\n")
+
+	// Exit event of a command that terminated while connection was down is
+	// lost: wait a bit for a late event and give up
+	for _, r := range execRunnersList() {
+		if execIsAlive(r.CmdID) {
+			continue
+		}
+		go func(r *ExecRunner) {
+			time.Sleep(2 * time.Second)
+			r.exit(ExecExitResult{cli.NewExitError(fmt.Sprintf("remote command %s terminated while connection was lost, its exit code cannot be recovered", r.CmdID), ExitCodeConnection), ExitCodeConnection})
+		}(r)
+	}
+}
+
+// execRunnersFail Terminate all running commands with a connection error
+func execRunnersFail(msg string) {
+	for _, r := range execRunnersList() {
+		r.exit(ExecExitResult{cli.NewExitError(fmt.Sprintf("%s (remote command %s may still be running)", msg, r.CmdID), ExitCodeConnection), ExitCodeConnection})
+	}
+}
+
+// execPendingClear Drop events kept for commands that have no runner (e.g.
+// commands of other clients or of runners that failed to start)
+func execPendingClear() {
+	execRunnersLock.Lock()
+	defer execRunnersLock.Unlock()
+	for id := range execPendingOut {
+		Log.Debugf("Drop output events of unknown command %s", id)
+	}
+	execPendingOut = make(map[string][]xaapiv1.ExecOutMsg)
+	execPendingExit = make(map[string]xaapiv1.ExecExitMsg)
+}
+
+// Start Send command to XDS agent and register runner to receive its events
+func (r *ExecRunner) Start() error {
+	LogPost("POST /exec %v", r.Args)
+	res := xaapiv1.ExecResult{}
+	if err := HTTPCli.Post("/exec", r.Args, &res); err != nil {
+		return err
+	}
+	r.CmdID = res.CmdID
+	Log.Debugf("Command ID: %v", r.CmdID)
+
+	execRunnersLock.Lock()
+	execRunners[r.CmdID] = r
+	pendingOut := execPendingOut[r.CmdID]
+	pendingExit, exited := execPendingExit[r.CmdID]
+	delete(execPendingOut, r.CmdID)
+	delete(execPendingExit, r.CmdID)
+	execRunnersLock.Unlock()
+
+	for _, ev := range pendingOut {
+		r.output(ev.Timestamp, ev.Stdout, ev.Stderr)
+	}
+	if exited {
+		r.exit(ExecExitResult{pendingExit.Error, pendingExit.Code})
+	}
+	return nil
+}
+
+// Wait Wait end of command; command is killed when it doesn't produce any
+// output during inactivityTimeout (when not null)
+func (r *ExecRunner) Wait(inactivityTimeout time.Duration) ExecExitResult {
+	var inactivityTimer *time.Timer
+	var inactivityChan <-chan time.Time
+	if inactivityTimeout > 0 {
+		inactivityTimer = time.NewTimer(inactivityTimeout)
+		defer inactivityTimer.Stop()
+		inactivityChan = inactivityTimer.C
+	}
+	killed := false
+
+	for {
+		select {
+		case <-r.activityChan:
+			if inactivityChan != nil {
+				inactivityTimer.Reset(inactivityTimeout)
+			}
+
+		case res := <-r.exitChan:
+			r.flushPending()
+
+			execRunnersLock.Lock()
+			delete(execRunners, r.CmdID)
+			execRunnersLock.Unlock()
+			if killed {
+				msg := fmt.Sprintf("remote command killed after %v without output", inactivityTimeout)
+				return ExecExitResult{cli.NewExitError(msg, ExitCodeTimeout), ExitCodeTimeout}
+			}
+			return res
+
+		case <-inactivityChan:
+			fmt.Fprintf(r.ErrW, "\nNo output produced during %v, killing remote command\n", inactivityTimeout)
+			if err := execSignalSend(r.CmdID, "SIGKILL"); err != nil {
+				msg := fmt.Sprintf("ERROR while killing remote command: %v", err)
+				return ExecExitResult{cli.NewExitError(msg, ExitCodeError), ExitCodeError}
+			}
+			inactivityChan = nil
+			killed = true
+		}
+	}
+}
+
+func (r *ExecRunner) output(timestamp, stdout, stderr string) {
+	r.outLock.Lock()
+	defer r.outLock.Unlock()
+
+	tm := ""
+	if r.WithTimestamp {
+		tm = timestamp + "| "
+	}
+	r.write(tm, r.StdoutRw.Write(stdout), r.StderrRw.Write(stderr))
+
+	// Data kept back by path rewriters (e.g. an interactive prompt ending
+	// with a path) is output anyway when no more output comes
+	if r.StdoutRw.Pending() || r.StderrRw.Pending() {
+		if r.flushTimer == nil {
+			r.flushTimer = time.AfterFunc(PathRewriterFlushDelay, func() {
+				r.outLock.Lock()
+				defer r.outLock.Unlock()
+				r.write("", r.StdoutRw.Flush(), r.StderrRw.Flush())
+			})
+		} else {
+			r.flushTimer.Reset(PathRewriterFlushDelay)
+		}
+	}
+
+	// Notify output activity (non blocking)
+	select {
+	case r.activityChan <- true:
+	default:
+	}
+}
+
+// flushPending Output data kept back by path rewriters
+func (r *ExecRunner) flushPending() {
+	r.outLock.Lock()
+	defer r.outLock.Unlock()
+	if r.flushTimer != nil {
+		r.flushTimer.Stop()
+	}
+	r.write("", r.StdoutRw.Flush(), r.StderrRw.Flush())
+}
+
+func (r *ExecRunner) write(tm, stdout, stderr string) {
+	r.Diags.Feed(r.CmdID+":stdout", stdout)
+	r.Diags.Feed(r.CmdID+":stderr", stderr)
+	if stdout != "" {
+		fmt.Fprintf(r.OutW, "%s%s", tm, stdout)
+	}
+	if stderr != "" {
+		fmt.Fprintf(r.ErrW, "%s%s", tm, stderr)
+	}
+}
+
+func (r *ExecRunner) exit(res ExecExitResult) {
+	// Only first exit result is relevant
+	select {
+	case r.exitChan <- res:
+	default:
+	}
+}
@@ -0,0 +1,363 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/urfave/cli"
+)
+
+// WatchDefaultExcludes Patterns of files always ignored by watch mode (VCS
+// directories and editor temporary files)
+var WatchDefaultExcludes = []string{".git", ".svn", ".hg", "*.swp", "*.swx", "*~", ".#*", "4913", ".xds-fetch-*"}
+
+// WatchBuildExcludes Patterns of usual build output, ignored by default by
+// exec --watch: on pathmap projects, files written by the command on server
+// side are also local changes that would otherwise trigger a new execution
+var WatchBuildExcludes = []string{"build", "build-*", "_build", "CMakeFiles", "CMakeCache.txt",
+	".deps", ".libs", "*.o", "*.obj", "*.a", "*.so", "*.so.*", "*.lo", "*.la", "*.d", "*.pyc", "*.log"}
+
+// FileWatcher Recursive watcher of a local directory tree, changes are
+// reported by batches once no more change occurs during debounce duration
+type FileWatcher struct {
+	Changes chan []string // relative paths of changed files
+	Errors  chan error
+
+	root     string
+	includes []string
+	excludes []string
+	debounce time.Duration
+	watcher  *fsnotify.Watcher
+	done     chan bool
+}
+
+// NewFileWatcher Create a watcher of root directory tree
+func NewFileWatcher(root string, includes, excludes []string, debounce time.Duration) (*FileWatcher, error) {
+	for _, p := range append(includes, excludes...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid watch pattern '%s'", p)
+		}
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &FileWatcher{
+		Changes:  make(chan []string),
+		Errors:   make(chan error, 1),
+		root:     filepath.Clean(root),
+		includes: includes,
+		excludes: append(append([]string{}, WatchDefaultExcludes...), excludes...),
+		debounce: debounce,
+		watcher:  fsw,
+		done:     make(chan bool),
+	}
+	if err := w.addTree(w.root); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Close Stop watching
+func (w *FileWatcher) Close() {
+	close(w.done)
+	w.watcher.Close()
+}
+
+// addTree Watch a directory and all its not excluded sub-directories
+func (w *FileWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			// Directory removed in the meantime
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p != w.root && watchMatchAny(w.excludes, w.relPath(p)) {
+			return filepath.SkipDir
+		}
+		Log.Debugf("Watch directory %s", p)
+		return w.watcher.Add(p)
+	})
+}
+
+func (w *FileWatcher) relPath(p string) string {
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (w *FileWatcher) run() {
+	changed := make(map[string]bool)
+	var timer *time.Timer
+	var timerChan <-chan time.Time
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			rel := w.relPath(ev.Name)
+			if watchMatchAny(w.excludes, rel) {
+				continue
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err := w.addTree(ev.Name); err != nil {
+						Log.Warningf("Cannot watch directory %s: %v", ev.Name, err)
+					}
+					continue
+				}
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			if len(w.includes) > 0 && !watchMatchAny(w.includes, rel) {
+				continue
+			}
+			Log.Debugf("Watch event: %v", ev)
+			changed[rel] = true
+			if timer == nil {
+				timer = time.NewTimer(w.debounce)
+				timerChan = timer.C
+			} else {
+				timer.Reset(w.debounce)
+			}
+
+		case <-timerChan:
+			timer, timerChan = nil, nil
+			files := make([]string, 0, len(changed))
+			for f := range changed {
+				files = append(files, f)
+			}
+			sort.Strings(files)
+			changed = make(map[string]bool)
+			select {
+			case w.Changes <- files:
+			case <-w.done:
+				return
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case w.Errors <- err:
+			default:
+			}
+		}
+	}
+}
+
+// watchMatchAny Return true when a relative path matches one of patterns: a
+// pattern without slash is matched against each path element (so 'build'
+// matches the whole build directory tree), otherwise against the path and
+// its parent directories, ** matching any number of directories
+func watchMatchAny(patterns []string, rel string) bool {
+	elems := strings.Split(rel, "/")
+	for _, p := range patterns {
+		p = strings.Trim(p, "/")
+		if !strings.Contains(p, "/") {
+			for _, e := range elems {
+				if m, _ := path.Match(p, e); m {
+					return true
+				}
+			}
+			continue
+		}
+		pElems := strings.Split(p, "/")
+		for i := range elems {
+			if watchGlobMatch(pElems, elems[:i+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// watchGlobMatch Match path elements against pattern elements, a ** element
+// matches zero or more path elements
+func watchGlobMatch(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elems); i++ {
+			if watchGlobMatch(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	if m, _ := path.Match(pattern[0], elems[0]); !m {
+		return false
+	}
+	return watchGlobMatch(pattern[1:], elems[1:])
+}
+
+// execWatch executes a command and then executes it again each time files of
+// project local tree change, until an interruption signal is received
+func execWatch(ctx *cli.Context, prj xaapiv1.ProjectConfig, args xaapiv1.ExecArgs, sigs chan os.Signal, inactivityTimeout, syncTimeout time.Duration) error {
+	debounce, err := ParseTimeout(ctx.String("watch-debounce"))
+	if err != nil {
+		return cli.NewExitError("--watch-debounce: "+err.Error(), ExitCodeInvalidArgs)
+	}
+	excludes := ctx.StringSlice("watch-exclude")
+	if !ctx.Bool("watch-no-build-excludes") {
+		excludes = append(append([]string{}, WatchBuildExcludes...), excludes...)
+	}
+	w, err := NewFileWatcher(prj.ClientPath, ctx.StringSlice("watch-include"), excludes, debounce)
+	if err != nil {
+		return cli.NewExitError("Cannot watch project local tree: "+err.Error(), ExitCodeError)
+	}
+	defer w.Close()
+
+	clearScreen := ctx.Bool("watch-clear") && IsTerminal(os.Stdout)
+	for {
+		if clearScreen {
+			fmt.Fprint(os.Stdout, "\033[H\033[2J")
+		}
+
+		// Start command
+		start := time.Now()
+		diags := execDiagCollectorNew(ctx, prj)
+		r := execRunnerNew(ctx, prj, args, os.Stdout, os.Stderr)
+		r.Diags = diags
+		var resChan chan ExecExitResult
+		if err := r.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: cannot start command: %v\n", err)
+		} else {
+			resChan = make(chan ExecExitResult, 1)
+			go func(r *ExecRunner, c chan ExecExitResult) {
+				c <- r.Wait(inactivityTimeout)
+			}(r, resChan)
+		}
+
+		// Wait for either end of command, files changes or interruption
+		var changes []string
+		for changes == nil {
+			select {
+			case res := <-resChan:
+				resChan = nil
+				if err := execDiagReport(ctx, diags, os.Stderr); err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				}
+				msg := fmt.Sprintf("exited with code %d", res.Code)
+				if res.Error != nil {
+					msg += " (" + res.Error.Error() + ")"
+				}
+				fmt.Fprintf(os.Stderr, "\n[watch] Command %s after %v, waiting for changes (Ctrl+C to quit)...\n",
+					msg, DurationRound(time.Since(start), time.Millisecond))
+
+			case changes = <-w.Changes:
+
+			case err := <-w.Errors:
+				Log.Warningf("Watch error: %v", err)
+
+			case <-sigs:
+				if resChan != nil {
+					execWatchCancel(r, resChan, sigs)
+				}
+				fmt.Fprintf(os.Stderr, "\n[watch] Stopped\n")
+				return nil
+			}
+		}
+
+		if resChan != nil {
+			fmt.Fprintf(os.Stderr, "\n[watch] Changes detected, cancelling running command...\n")
+			execWatchCancel(r, resChan, sigs)
+		}
+
+		// Wait until modified files are synchronized on server side, files
+		// changed in the meantime are synchronized too before next run
+		for len(changes) > 0 {
+			if len(changes) == 1 {
+				fmt.Fprintf(os.Stderr, "[watch] %s changed\n", changes[0])
+			} else {
+				fmt.Fprintf(os.Stderr, "[watch] %d files changed\n", len(changes))
+			}
+			requested := false
+			if prj.Type == xaapiv1.TypeCloudSync {
+				if err := ProjectSyncRequest(prj.ID); err != nil {
+					Log.Warningf("Cannot request project synchronization: %v", err)
+				} else {
+					requested = true
+				}
+			}
+			if !ctx.Bool("no-sync-wait") {
+				if err := ProjectSyncWait(prj.ID, syncTimeout, requested); err != nil {
+					fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+				}
+			}
+
+			select {
+			case changes = <-w.Changes:
+			default:
+				changes = nil
+			}
+		}
+	}
+}
+
+// execWatchCancel interrupts a running command and waits for its end; it is
+// killed when it doesn't terminate within 5 seconds or on a new signal
+func execWatchCancel(r *ExecRunner, resChan chan ExecExitResult, sigs chan os.Signal) {
+	if err := execSignalSend(r.CmdID, "SIGINT"); err != nil {
+		Log.Debugf("Cannot interrupt command %s: %v", r.CmdID, err)
+	}
+	select {
+	case <-resChan:
+		return
+	case <-sigs:
+	case <-time.After(5 * time.Second):
+	}
+	if err := execSignalSend(r.CmdID, "SIGKILL"); err != nil {
+		Log.Debugf("Cannot kill command %s: %v", r.CmdID, err)
+	}
+	select {
+	case <-resChan:
+	case <-time.After(10 * time.Second):
+		Log.Warningf("Command %s still not terminated", r.CmdID)
+	}
+}
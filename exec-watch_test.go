@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchMatchAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		rel      string
+		want     bool
+	}{
+		{[]string{"*.o"}, "main.o", true},
+		{[]string{"*.o"}, "src/lib/util.o", true},
+		{[]string{"*.o"}, "main.c", false},
+		// Pattern without slash matches any element: whole directory tree
+		{[]string{"build"}, "build", true},
+		{[]string{"build"}, "build/src/main.o", true},
+		{[]string{"build"}, "app/build/x", true},
+		{[]string{"build"}, "builds/x", false},
+		{[]string{"build-*"}, "build-arm/x", true},
+		// Pattern with slash matches path or one of its parent directories
+		{[]string{"src/gen"}, "src/gen/a.c", true},
+		{[]string{"/src/gen/"}, "src/gen", true},
+		{[]string{"src/gen"}, "lib/src/gen/a.c", false},
+		{[]string{"src/*.c"}, "src/main.c", true},
+		{[]string{"src/*.c"}, "src/sub/main.c", false},
+		// ** matches any number of directories
+		{[]string{"src/**/*.h"}, "src/a.h", true},
+		{[]string{"src/**/*.h"}, "src/a/b/c.h", true},
+		{[]string{"src/**/*.h"}, "src/a/b/c.c", false},
+		{[]string{"**/test"}, "a/b/test/x.c", true},
+		{[]string{"**/test"}, "test", true},
+		{[]string{"src/**"}, "src/a/b", true},
+		{[]string{"src/**"}, "lib/a", false},
+		{[]string{"*.c", "*.h"}, "inc/a.h", true},
+		{nil, "a.c", false},
+	}
+	for _, tt := range tests {
+		if got := watchMatchAny(tt.patterns, tt.rel); got != tt.want {
+			t.Errorf("watchMatchAny(%q, %q) = %v, want %v", tt.patterns, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	if err := os.MkdirAll(filepath.Join(dir, "build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewFileWatcher(dir, []string{"*.c", "*.o"}, []string{"build"}, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// A burst of changes is reported as a single batch; excludes take
+	// precedence over includes, files not included are ignored
+	for _, f := range []string{"b.c", "a.c", "b.c", "build/x.o", "notes.txt", ".git"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case files := <-w.Changes:
+		if want := []string{"a.c", "b.c"}; !reflect.DeepEqual(files, want) {
+			t.Errorf("changes = %q, want %q", files, want)
+		}
+	case err := <-w.Errors:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+
+	// Files of a new sub-directory are watched too
+	sub := filepath.Join(dir, "src")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := ioutil.WriteFile(filepath.Join(sub, "main.c"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case files := <-w.Changes:
+		if want := []string{"src/main.c"}; !reflect.DeepEqual(files, want) {
+			t.Errorf("changes = %q, want %q", files, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("change in new directory not reported")
+	}
+}
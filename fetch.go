@@ -0,0 +1,224 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/urfave/cli"
+)
+
+// FetchArgs Arguments of a fetch of files from server-side project tree
+type FetchArgs struct {
+	Project  xaapiv1.ProjectConfig
+	RPath    string   // relative path into project, patterns are relative to it
+	Patterns []string // shell glob patterns (** is supported)
+	DestDir  string   // local destination, default ClientPath/RPath
+}
+
+// FetchedFile Description of a fetched file
+type FetchedFile struct {
+	Path      string // relative to rpath
+	LocalPath string
+	Mode      os.FileMode
+	MTime     time.Time
+	Size      int64
+	Hash      string // sha256
+}
+
+var fetchPatternRe = regexp.MustCompile(`^[a-zA-Z0-9_.*?/@%+,=:\[\]{}-]+$`)
+
+// ProjectFetch Download files matching patterns from server-side project tree.
+// Transfer is done using remote commands (listing then base64 encoding of
+// files), files whose content hash has not changed are skipped.
+func ProjectFetch(args FetchArgs) ([]FetchedFile, error) {
+	for _, p := range args.Patterns {
+		if !fetchPatternRe.MatchString(p) || strings.HasPrefix(p, "/") || strings.Contains(p, "..") {
+			return nil, cli.NewExitError(fmt.Sprintf("invalid fetch pattern '%s' (must be a relative glob pattern)", p), ExitCodeInvalidArgs)
+		}
+	}
+	destDir := args.DestDir
+	if destDir == "" {
+		destDir = filepath.Join(args.Project.ClientPath, args.RPath)
+	}
+
+	// List remote files
+	script := "shopt -s globstar nullglob dotglob; for f in " + strings.Join(args.Patterns, " ") +
+		`; do [ -f "$f" ] && echo "$(stat -c '%a %Y %s' "$f") $(sha256sum "$f" | cut -d' ' -f1) $f"; done`
+	out, err := fetchRemoteOutput(args, script)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list remote files: %v", err)
+	}
+	files := []FetchedFile{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		mode, _ := strconv.ParseUint(fields[0], 8, 32)
+		mtime, _ := strconv.ParseInt(fields[1], 10, 64)
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		files = append(files, FetchedFile{
+			Path:      fields[4],
+			LocalPath: filepath.Join(destDir, filepath.FromSlash(fields[4])),
+			Mode:      os.FileMode(mode),
+			MTime:     time.Unix(mtime, 0),
+			Size:      size,
+			Hash:      fields[3],
+		})
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No remote file matches %v\n", args.Patterns)
+		return files, nil
+	}
+
+	nbFetched, nbSkipped := 0, 0
+	for i, f := range files {
+		localPath := f.LocalPath
+		progress := fmt.Sprintf("[%d/%d] %s", i+1, len(files), f.Path)
+
+		if fetchLocalHash(localPath) == f.Hash {
+			fmt.Fprintf(os.Stderr, "%s: unchanged\n", progress)
+			nbSkipped++
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: fetching %d bytes...\n", progress, f.Size)
+			if err := fetchFile(args, f); err != nil {
+				return nil, fmt.Errorf("cannot fetch %s: %v", f.Path, err)
+			}
+			nbFetched++
+		}
+
+		// Preserve mode and timestamp
+		if err := os.Chmod(localPath, f.Mode); err != nil {
+			return nil, err
+		}
+		if err := os.Chtimes(localPath, f.MTime, f.MTime); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%d file(s) fetched into %s, %d unchanged.\n", nbFetched, destDir, nbSkipped)
+	return files, nil
+}
+
+// fetchRemoteOutput Execute a shell command in project tree and return its stdout
+func fetchRemoteOutput(args FetchArgs, cmd string) (string, error) {
+	var outBuf bytes.Buffer
+	if err := fetchRemote(args, cmd, &outBuf); err != nil {
+		return "", err
+	}
+	return outBuf.String(), nil
+}
+
+// fetchRemote Execute a shell command in project tree, its stdout is written
+// into outW as it is received
+func fetchRemote(args FetchArgs, cmd string, outW io.Writer) error {
+	var errBuf bytes.Buffer
+	r := NewExecRunner(xaapiv1.ExecArgs{
+		ID:         args.Project.ID,
+		Cmd:        cmd,
+		RPath:      args.RPath,
+		CmdTimeout: -1,
+	}, outW, &errBuf)
+	if err := r.Start(); err != nil {
+		return err
+	}
+	res := r.Wait(0)
+	if res.Code != 0 {
+		msg := strings.TrimSpace(errBuf.String())
+		if msg == "" && res.Error != nil {
+			msg = res.Error.Error()
+		}
+		return fmt.Errorf("remote command failed with code %d: %s", res.Code, msg)
+	}
+	return nil
+}
+
+// fetchFile Download a remote file: base64 output (wrapped lines) of remote
+// command is decoded on the fly into a temporary file that replaces local
+// file once its size and hash match the ones of remote listing
+func fetchFile(args FetchArgs, f FetchedFile) error {
+	if err := os.MkdirAll(filepath.Dir(f.LocalPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.LocalPath), ".xds-fetch-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	type decodeResult struct {
+		size int64
+		err  error
+	}
+	h := sha256.New()
+	pr, pw := io.Pipe()
+	decodeChan := make(chan decodeResult, 1)
+	go func() {
+		// base64 decoder ignores newlines
+		n, err := io.Copy(io.MultiWriter(tmp, h), base64.NewDecoder(base64.StdEncoding, pr))
+		pr.CloseWithError(err) // stop writer on decoding error
+		decodeChan <- decodeResult{n, err}
+	}()
+
+	err = fetchRemote(args, "base64 -- "+ShellQuote(f.Path), pw)
+	pw.Close()
+	res := <-decodeChan
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	switch {
+	case err != nil:
+		return err
+	case res.err != nil:
+		return fmt.Errorf("cannot decode: %v", res.err)
+	case res.size != f.Size:
+		return fmt.Errorf("size mismatch: %d bytes received, %d expected", res.size, f.Size)
+	case hex.EncodeToString(h.Sum(nil)) != f.Hash:
+		return fmt.Errorf("hash mismatch (file changed while fetching it?)")
+	}
+	return os.Rename(tmp.Name(), f.LocalPath)
+}
+
+// fetchLocalHash Return sha256 of a local file or an empty string
+func fetchLocalHash(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
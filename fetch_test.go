@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestProjectFetchInvalidPattern(t *testing.T) {
+	// Patterns are checked before any request to XDS agent
+	patterns := []string{
+		"/etc/passwd",
+		"../secret",
+		"build/../../x",
+		"a b",
+		"$(reboot)",
+		"x;ls",
+		"`id`",
+		"",
+	}
+	for _, p := range patterns {
+		_, err := ProjectFetch(FetchArgs{Patterns: []string{"build/*.bin", p}})
+		if e, ok := err.(cli.ExitCoder); !ok || e.ExitCode() != ExitCodeInvalidArgs {
+			t.Errorf("ProjectFetch(%q) error = %v, want exit code %d", p, err, ExitCodeInvalidArgs)
+		}
+	}
+}
+
+func TestFetchLocalHash(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	file := filepath.Join(dir, "gcc")
+	if err := ioutil.WriteFile(file, []byte("gcc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "94f0fa7f897ccce65856dc5a98bae4bf6957a346766613d79414c976d093aa4a"
+	if got := fetchLocalHash(file); got != want {
+		t.Errorf("fetchLocalHash() = %s, want %s", got, want)
+	}
+	if got := fetchLocalHash(filepath.Join(dir, "missing")); got != "" {
+		t.Errorf("fetchLocalHash() of missing file = %s, want empty string", got)
+	}
+}
@@ -26,7 +26,9 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"text/tabwriter"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
@@ -73,11 +75,17 @@ var HTTPCli *common.HTTPClient
 // IOsk Global variable that hold SocketIo client
 var IOsk *socketio_client.Client
 
+// ioskURL, ioskHandlers and ioskEvents keep what is needed to re-establish
+// SocketIo connection (see IOskReconnect)
+var ioskURL string
+var ioskLock sync.Mutex
+var ioskHandlers = make(map[string]interface{})
+var ioskEvents = []string{}
+
 // exitError exists this program with the specified error
 func exitError(code int, f string, a ...interface{}) {
 	earlyDisplay()
-	err := fmt.Sprintf(f, a...)
-	fmt.Fprintf(os.Stderr, err+"\n")
+	errPrint(code, exitCodeKinds[code], "", fmt.Sprintf(f, a...), 0)
 	os.Exit(code)
 }
 
@@ -155,13 +163,13 @@ func main() {
 			env = fb.EnvVar
 			usage = fb.Usage
 		default:
-			exitError(1, "Un-implemented option type")
+			exitError(ExitCodeError, "Un-implemented option type")
 		}
 		if env != "" {
 			dynDesc += fmt.Sprintf("\n %s \t\t %s", env, usage)
 		}
 	}
-	app.Description = appDescription + dynDesc
+	app.Description = appDescription + dynDesc + "\n" + ExitCodesHelp
 
 	// Declare global flags
 	app.Flags = []cli.Flag{
@@ -188,6 +196,24 @@ func main() {
 			Value:  "",
 			Usage:  "overwrite remote XDS server url (default value set in xds-agent-config.json file)",
 		},
+		cli.StringFlag{
+			Name:   "reconnect-timeout",
+			EnvVar: "XDS_RECONNECT_TIMEOUT",
+			Value:  "5m",
+			Usage:  "maximum duration of reconnection attempts when connection to XDS agent is lost (0 to disable: running commands then fail)",
+		},
+		cli.StringFlag{
+			Name:   "error-format",
+			EnvVar: "XDS_ERROR_FORMAT",
+			Value:  "text",
+			Usage:  "format of errors printed on stderr: text or json (see EXIT CODES)",
+		},
+		cli.StringFlag{
+			Name:   "output, o",
+			EnvVar: "XDS_OUTPUT",
+			Value:  "table",
+			Usage:  OutputFormatsHelp + " (list and get commands)",
+		},
 		cli.BoolFlag{
 			Name:   "timestamp, ts",
 			EnvVar: "XDS_TIMESTAMP",
@@ -201,18 +227,28 @@ func main() {
 	initCmdProjects(&app.Commands)
 	initCmdSdks(&app.Commands)
 	initCmdExec(&app.Commands)
+	initCmdJobs(&app.Commands)
+	initCmdRun(&app.Commands)
+	initCmdHistory(&app.Commands)
+	initCmdEvents(&app.Commands)
+	initCmdWorkspace(&app.Commands)
 	initCmdMisc(&app.Commands)
 
 	// Add --config option to all commands to support --config option either before or after command verb
 	// IOW support following both syntaxes:
 	//   xds-cli exec --config myprj.conf ...
 	//   xds-cli --config myprj.conf exec ...
+	// (--output option is also supported after sub-command verb)
+	// and handle errors returned by all commands (exit code and error format)
 	for i, cmd := range app.Commands {
 		if len(cmd.Flags) > 0 {
 			app.Commands[i].Flags = append(cmd.Flags, cli.StringFlag{Hidden: true, Name: "config, c"})
 		}
+		app.Commands[i].Action = actionWrap(cmd.Action, cmd.Name)
 		for j, subCmd := range cmd.Subcommands {
-			app.Commands[i].Subcommands[j].Flags = append(subCmd.Flags, cli.StringFlag{Hidden: true, Name: "config, c"})
+			app.Commands[i].Subcommands[j].Flags = append(subCmd.Flags, cli.StringFlag{Hidden: true, Name: "config, c"},
+				cli.StringFlag{Hidden: true, Name: "output, o"})
+			app.Commands[i].Subcommands[j].Action = actionWrap(subCmd.Action, cmd.Name+" "+subCmd.Name)
 		}
 	}
 
@@ -221,12 +257,24 @@ func main() {
 
 	// Early and manual processing of --config option in order to set XDS_xxx
 	// variables before parsing of option by app cli
+	// (--error-format option is also processed early to report config file errors)
 	confFile := os.Getenv("XDS_CONFIG")
+	if f := os.Getenv("XDS_ERROR_FORMAT"); f != "" {
+		ErrorFormat = f
+	}
 	for idx, a := range os.Args[1:] {
-		if a == "-c" || a == "--config" || a == "-config" {
-			confFile = os.Args[idx+2]
+		if strings.HasPrefix(a, "--error-format=") {
+			ErrorFormat = strings.TrimPrefix(a, "--error-format=")
+		}
+		if idx+2 >= len(os.Args) {
 			break
 		}
+		switch a {
+		case "-c", "--config", "-config":
+			confFile = os.Args[idx+2]
+		case "--error-format", "-error-format":
+			ErrorFormat = os.Args[idx+2]
+		}
 	}
 
 	// Load config file if requested
@@ -234,22 +282,22 @@ func main() {
 		earlyPrintf("confFile detected: %v", confFile)
 		confFile, err := common.ResolveEnvVar(confFile)
 		if err != nil {
-			exitError(1, "Error while resolving confFile: %v", err)
+			exitError(ExitCodeInvalidArgs, "Error while resolving confFile: %v", err)
 		}
 		earlyPrintf("Resolved confFile: %v", confFile)
 		if !common.Exists(confFile) {
-			exitError(1, "Error env config file not found")
+			exitError(ExitCodeNotFound, "Error env config file not found")
 		}
 		// Load config file variables that will overwrite env variables
 		err = godotenv.Overload(confFile)
 		if err != nil {
-			exitError(1, "Error loading env config file "+confFile)
+			exitError(ExitCodeInvalidArgs, "Error loading env config file "+confFile)
 		}
 
 		// Keep confFile settings in a map
 		EnvConfFileMap, err = godotenv.Read(confFile)
 		if err != nil {
-			exitError(1, "Error reading env config file "+confFile)
+			exitError(ExitCodeInvalidArgs, "Error reading env config file "+confFile)
 		}
 		earlyPrintf("EnvConfFileMap: %v", EnvConfFileMap)
 	}
@@ -272,7 +320,17 @@ func main() {
 		// Set logger level and formatter
 		if Log.Level, err = logrus.ParseLevel(loglevel); err != nil {
 			msg := fmt.Sprintf("Invalid log level : \"%v\"\n", loglevel)
-			return cli.NewExitError(msg, 1)
+			return ExitErrorHandle(cli.NewExitError(msg, ExitCodeInvalidArgs), "")
+		}
+		switch ErrorFormat = ctx.String("error-format"); ErrorFormat {
+		case "text", "json":
+		default:
+			msg := fmt.Sprintf("Invalid error format : \"%v\" (text or json)", ErrorFormat)
+			ErrorFormat = "text"
+			return ExitErrorHandle(cli.NewExitError(msg, ExitCodeInvalidArgs), "")
+		}
+		if OutputDefault, err = OutputParse(ctx.String("output")); err != nil {
+			return ExitErrorHandle(cli.NewExitError(err.Error(), ExitCodeInvalidArgs), "")
 		}
 		Log.Formatter = &logrus.TextFormatter{}
 
@@ -283,7 +341,7 @@ func main() {
 		if err = XdsConnInit(ctx); err != nil {
 			// Directly call HandleExitCoder to avoid to print help (ShowAppHelp)
 			// Note that this function wil never return and program will exit
-			cli.HandleExitCoder(err)
+			cli.HandleExitCoder(ExitErrorHandle(err, ""))
 		}
 
 		return nil
@@ -294,7 +352,15 @@ func main() {
 		XdsConnClose()
 	}()
 
-	app.Run(os.Args)
+	// Errors of commands are handled by actionWrap, remaining ones are usage
+	// errors (already displayed by cli in text format)
+	if err := app.Run(os.Args); err != nil {
+		XdsConnClose()
+		if ErrorFormat == "json" {
+			errPrint(ExitCodeInvalidArgs, exitCodeKinds[ExitCodeInvalidArgs], "", err.Error(), 0)
+		}
+		os.Exit(ExitCodeInvalidArgs)
+	}
 }
 
 // XdsConnInit Initialized HTTP and WebSocket connection to XDS agent
@@ -350,28 +416,16 @@ func XdsConnInit(ctx *cli.Context) error {
 			}
 			errmsg = newErr
 		}
-		return cli.NewExitError(errmsg, 1)
+		return cli.NewExitError(errmsg, ExitCodeConnection)
 	}
 	HTTPCli.SetLogLevel(ctx.String("loglevel"))
 	Log.Infoln("HTTP session ID : ", HTTPCli.GetClientID())
 
 	// Create io Websocket client
-	Log.Debugln("Connecting IO.socket client on ", agentURL)
-
-	opts := &socketio_client.Options{
-		Transport: "websocket",
-		Header:    make(map[string][]string),
+	ioskURL = agentURL
+	if err := ioskConnect(); err != nil {
+		return cli.NewExitError("IO.socket connection error: "+err.Error(), ExitCodeConnection)
 	}
-	opts.Header["XDS-AGENT-SID"] = []string{HTTPCli.GetClientID()}
-
-	IOsk, err = socketio_client.NewClient(agentURL, opts)
-	if err != nil {
-		return cli.NewExitError("IO.socket connection error: "+err.Error(), 1)
-	}
-
-	IOsk.On("error", func(err error) {
-		fmt.Println("ERROR Websocket: ", err.Error())
-	})
 
 	ctx.App.Metadata["httpCli"] = HTTPCli
 	ctx.App.Metadata["ioskCli"] = IOsk
@@ -379,14 +433,14 @@ func XdsConnInit(ctx *cli.Context) error {
 	// Display version in logs (debug helpers)
 	ver := xaapiv1.XDSVersion{}
 	if err := XdsVersionGet(&ver); err != nil {
-		return cli.NewExitError("ERROR while retrieving XDS version: "+err.Error(), 1)
+		return cli.NewExitError("ERROR while retrieving XDS version: "+err.Error(), ExitCodeConnection)
 	}
 	Log.Infof("XDS Agent/Server version: %v", ver)
 
 	// Get current config and update connection to server when needed
 	xdsConf := xaapiv1.APIConfig{}
 	if err := XdsConfigGet(&xdsConf); err != nil {
-		return cli.NewExitError("ERROR while getting XDS config: "+err.Error(), 1)
+		return cli.NewExitError("ERROR while getting XDS config: "+err.Error(), ExitCodeConnection)
 	}
 	svrCfg := xdsConf.Servers[XdsServerIndexGet()]
 	if (serverURL != "" && svrCfg.URL != serverURL) || !svrCfg.Connected {
@@ -396,13 +450,94 @@ func XdsConnInit(ctx *cli.Context) error {
 		}
 		svrCfg.ConnRetry = 10
 		if err := XdsConfigSet(xdsConf); err != nil {
-			return cli.NewExitError("ERROR while updating XDS server URL: "+err.Error(), 1)
+			return cli.NewExitError("ERROR while updating XDS server URL: "+err.Error(), ExitCodeConnection)
+		}
+	}
+
+	return nil
+}
+
+// ioskConnect Create SocketIo client and register known event handlers
+func ioskConnect() error {
+	Log.Debugln("Connecting IO.socket client on ", ioskURL)
+
+	opts := &socketio_client.Options{
+		Transport: "websocket",
+		Header:    make(map[string][]string),
+	}
+	opts.Header["XDS-AGENT-SID"] = []string{HTTPCli.GetClientID()}
+
+	sk, err := socketio_client.NewClient(ioskURL, opts)
+	if err != nil {
+		return err
+	}
+
+	sk.On("error", func(err error) {
+		fmt.Println("ERROR Websocket: ", err.Error())
+	})
+	for evName, f := range ioskHandlers {
+		if err := sk.On(evName, f); err != nil {
+			return err
 		}
 	}
+	IOsk = sk
+	return nil
+}
+
+// IOskOn Register a SocketIo event handler (kept across reconnections)
+func IOskOn(evName string, f interface{}) error {
+	ioskLock.Lock()
+	defer ioskLock.Unlock()
+	ioskHandlers[evName] = f
+	return IOsk.On(evName, f)
+}
 
+// IOskEventRegister Register to an XDS event (registration is renewed on reconnection)
+func IOskEventRegister(evName string) error {
+	evReg := xaapiv1.EventRegisterArgs{Name: evName}
+	if err := HTTPCli.Post("/events/register", &evReg, nil); err != nil {
+		return err
+	}
+	ioskLock.Lock()
+	ioskEvents = append(ioskEvents, evName)
+	ioskLock.Unlock()
 	return nil
 }
 
+// IOskReconnect Re-establish SocketIo connection, retrying with an exponential
+// backoff (from 1 second up to 30 seconds) during maxDuration at most
+func IOskReconnect(maxDuration time.Duration) error {
+	ioskLock.Lock()
+	defer ioskLock.Unlock()
+
+	delay := time.Second
+	deadline := time.Now().Add(maxDuration)
+	for {
+		err := ioskConnect()
+		if err == nil {
+			for _, evName := range ioskEvents {
+				evReg := xaapiv1.EventRegisterArgs{Name: evName}
+				if err = HTTPCli.Post("/events/register", &evReg, nil); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			Log.Infof("IO.socket reconnected")
+			return nil
+		}
+		Log.Debugf("IO.socket reconnection failed: %v", err)
+
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("cannot reconnect to XDS agent: %v", err)
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
 // XdsConnClose Terminate connection to XDS agent
 func XdsConnClose() {
 	Log.Debugf("Closing HTTP client session...")
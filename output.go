@@ -0,0 +1,356 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/urfave/cli"
+)
+
+// OutputFormatsHelp Description of formats supported by --output option
+const OutputFormatsHelp = "output format: table, wide, json, yaml, template=<go template> or jsonpath=<expr>"
+
+// OutputSpec Output format set by --output option
+type OutputSpec struct {
+	Format string // table, wide, json, yaml, template or jsonpath
+	Arg    string // template or jsonpath expression
+	tmpl   *template.Template
+	path   []jsonPathStep
+}
+
+// OutputDefault Output format set by global --output option
+var OutputDefault = OutputSpec{Format: "table"}
+
+// OutputParse Parse value of --output option
+func OutputParse(value string) (OutputSpec, error) {
+	spec := OutputSpec{Format: value}
+	if idx := strings.Index(value, "="); idx >= 0 {
+		spec.Format, spec.Arg = value[:idx], value[idx+1:]
+	}
+	switch spec.Format {
+	case "", "table":
+		spec.Format = "table"
+	case "wide", "json", "yaml":
+	case "template", "go-template":
+		spec.Format = "template"
+		tmpl, err := template.New("output").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(spec.Arg)
+		if err != nil {
+			return spec, fmt.Errorf("invalid output template: %v", err)
+		}
+		spec.tmpl = tmpl
+	case "jsonpath":
+		path, err := jsonPathParse(spec.Arg)
+		if err != nil {
+			return spec, err
+		}
+		spec.path = path
+	default:
+		return spec, fmt.Errorf("invalid output format '%s' (%s)", value, OutputFormatsHelp)
+	}
+	return spec, nil
+}
+
+// OutputSpecGet Return output format set by --output option, either set
+// before or after command verb
+func OutputSpecGet(ctx *cli.Context) (OutputSpec, error) {
+	if !ctx.IsSet("output") {
+		return OutputDefault, nil
+	}
+	spec, err := OutputParse(ctx.String("output"))
+	if err != nil {
+		return spec, cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
+	}
+	return spec, nil
+}
+
+// OutputRender Render data using output format set by --output option (either
+// set before or after command verb); table and wide formats are rendered by
+// tableFn. Other formats use json names of data fields (IOW xaapiv1 structs).
+func OutputRender(ctx *cli.Context, data interface{}, tableFn func(wide bool)) error {
+	spec, err := OutputSpecGet(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch spec.Format {
+	case "table", "wide":
+		tableFn(spec.Format == "wide")
+		return nil
+	case "json":
+		b, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return cli.NewExitError(err.Error(), ExitCodeError)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	// Other formats work on generic data decoded from json
+	generic, err := outputGeneric(data)
+	if err != nil {
+		return cli.NewExitError(err.Error(), ExitCodeError)
+	}
+	switch spec.Format {
+	case "yaml":
+		var buf bytes.Buffer
+		yamlWrite(&buf, generic)
+		fmt.Print(buf.String())
+	case "template":
+		if err := spec.tmpl.Execute(os.Stdout, generic); err != nil {
+			return cli.NewExitError("output template: "+err.Error(), ExitCodeInvalidArgs)
+		}
+	case "jsonpath":
+		res, err := jsonPathEval(spec.path, generic)
+		if err != nil {
+			return cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
+		}
+		for _, v := range res {
+			fmt.Println(outputScalar(v))
+		}
+	}
+	return nil
+}
+
+func outputGeneric(data interface{}) (interface{}, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&generic)
+	return generic, err
+}
+
+// outputScalar Return raw value of a scalar, compact json for others
+func outputScalar(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	case nil:
+		return "null"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// jsonPathStep One step of a jsonpath expression: a field name, an index or
+// a wildcard (all fields or items)
+type jsonPathStep struct {
+	field    string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+var jsonPathStepRe = regexp.MustCompile(`^(?:\.([A-Za-z0-9_-]+)|\.\*|\[(\*|-?\d+)\]|\['([^']*)'\])`)
+
+// jsonPathParse Parse a jsonpath expression (subset supported: {.a.b[0].c},
+// .items[*].id, $.a['b-c'])
+func jsonPathParse(expr string) ([]jsonPathStep, error) {
+	e := strings.TrimSpace(expr)
+	if strings.HasPrefix(e, "{") && strings.HasSuffix(e, "}") {
+		e = strings.TrimSpace(e[1 : len(e)-1])
+	}
+	e = strings.TrimPrefix(e, "$")
+	if e != "" && e[0] != '.' && e[0] != '[' {
+		e = "." + e
+	}
+
+	steps := []jsonPathStep{}
+	for e != "" {
+		m := jsonPathStepRe.FindStringSubmatch(e)
+		if m == nil {
+			return nil, fmt.Errorf("invalid jsonpath expression '%s' near '%s'", expr, e)
+		}
+		switch {
+		case m[1] != "":
+			steps = append(steps, jsonPathStep{field: m[1]})
+		case m[2] == "*" || m[0] == ".*":
+			steps = append(steps, jsonPathStep{wildcard: true})
+		case m[2] != "":
+			idx, _ := strconv.Atoi(m[2])
+			steps = append(steps, jsonPathStep{index: idx, isIndex: true})
+		default:
+			steps = append(steps, jsonPathStep{field: m[3]})
+		}
+		e = e[len(m[0]):]
+	}
+	return steps, nil
+}
+
+// jsonPathEval Return values selected by a jsonpath expression
+func jsonPathEval(steps []jsonPathStep, data interface{}) ([]interface{}, error) {
+	cur := []interface{}{data}
+	for _, s := range steps {
+		next := []interface{}{}
+		for _, v := range cur {
+			switch t := v.(type) {
+			case map[string]interface{}:
+				if s.wildcard {
+					keys := make([]string, 0, len(t))
+					for k := range t {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						next = append(next, t[k])
+					}
+				} else if val, ok := t[s.field]; ok && !s.isIndex {
+					next = append(next, val)
+				}
+			case []interface{}:
+				switch {
+				case s.wildcard:
+					next = append(next, t...)
+				case s.isIndex:
+					idx := s.index
+					if idx < 0 {
+						idx += len(t)
+					}
+					if idx >= 0 && idx < len(t) {
+						next = append(next, t[idx])
+					}
+				default:
+					// Field of a list applies to all items
+					for _, item := range t {
+						if m, ok := item.(map[string]interface{}); ok {
+							if val, ok := m[s.field]; ok {
+								next = append(next, val)
+							}
+						}
+					}
+				}
+			}
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// yamlWrite Write generic data (decoded from json) as yaml
+func yamlWrite(buf *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) > 0 {
+			yamlMap(buf, t, "", "")
+			return
+		}
+	case []interface{}:
+		if len(t) > 0 {
+			yamlList(buf, t, "")
+			return
+		}
+	}
+	buf.WriteString(strings.TrimPrefix(yamlValueString(v), " ") + "\n")
+}
+
+// yamlMap Write map keys (sorted), first key is prefixed by first and other
+// ones by indent
+func yamlMap(buf *bytes.Buffer, m map[string]interface{}, indent, first string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i == 0 {
+			buf.WriteString(first)
+		} else {
+			buf.WriteString(indent)
+		}
+		buf.WriteString(yamlScalar(k) + ":")
+		yamlValue(buf, m[k], indent)
+	}
+}
+
+func yamlList(buf *bytes.Buffer, l []interface{}, indent string) {
+	for _, item := range l {
+		if m, ok := item.(map[string]interface{}); ok && len(m) > 0 {
+			yamlMap(buf, m, indent+"  ", indent+"- ")
+			continue
+		}
+		buf.WriteString(indent + "-")
+		yamlValue(buf, item, indent)
+	}
+}
+
+// yamlValue Write a value following a key or a list dash
+func yamlValue(buf *bytes.Buffer, v interface{}, indent string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) > 0 {
+			buf.WriteString("\n")
+			yamlMap(buf, t, indent+"  ", indent+"  ")
+			return
+		}
+	case []interface{}:
+		if len(t) > 0 {
+			buf.WriteString("\n")
+			yamlList(buf, t, indent+"  ")
+			return
+		}
+	}
+	buf.WriteString(yamlValueString(v) + "\n")
+}
+
+func yamlValueString(v interface{}) string {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return " {}"
+	case []interface{}:
+		return " []"
+	case string:
+		return " " + yamlScalar(t)
+	}
+	return " " + outputScalar(v)
+}
+
+var yamlPlainRe = regexp.MustCompile(`^[A-Za-z0-9_/.][A-Za-z0-9_/. ()@+=,-]*$`)
+
+// yamlReservedRe Plain scalars that yaml 1.1 or 1.2 parsers don't read as
+// strings (booleans, null, numbers in any base, dates and times)
+var yamlReservedRe = regexp.MustCompile(`^(?i:true|false|yes|no|on|off|y|n|null|~|[-+]?\.?[0-9][0-9_.:eE+-]*|0x[0-9a-f_]+|0o[0-7_]+|0b[01_]+|\.inf|\.nan)$`)
+
+// yamlScalar Return a string as a yaml scalar, quoted when needed
+func yamlScalar(s string) string {
+	if yamlPlainRe.MatchString(s) && !yamlReservedRe.MatchString(s) && !strings.HasSuffix(s, " ") {
+		return s
+	}
+	return strconv.Quote(s)
+}
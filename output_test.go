@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		format  string
+		arg     string
+		wantErr bool
+	}{
+		{"", "table", "", false},
+		{"table", "table", "", false},
+		{"wide", "wide", "", false},
+		{"json", "json", "", false},
+		{"yaml", "yaml", "", false},
+		{"template={{.id}}", "template", "{{.id}}", false},
+		{"go-template={{.id}}", "template", "{{.id}}", false},
+		{"jsonpath={.items[0].id}", "jsonpath", "{.items[0].id}", false},
+		{"jsonpath=a=b", "jsonpath", "a=b", true},
+		{"template={{.id", "template", "{{.id", true},
+		{"xml", "xml", "", true},
+	}
+	for _, tt := range tests {
+		spec, err := OutputParse(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("OutputParse(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if spec.Format != tt.format || spec.Arg != tt.arg {
+			t.Errorf("OutputParse(%q) = %q %q, want %q %q", tt.value, spec.Format, spec.Arg, tt.format, tt.arg)
+		}
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	var data interface{}
+	dec := json.NewDecoder(strings.NewReader(`{
+		"items": [{"id": "a", "n": 1}, {"id": "b", "n": 2}, {"id": "c"}],
+		"labels": {"z": "last", "a": "first"},
+		"dash-key": true,
+		"empty": []
+	}`))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr    string
+		want    []string
+		wantErr bool
+	}{
+		{"{.items[0].id}", []string{"a"}, false},
+		{".items[-1].id", []string{"c"}, false},
+		{"items[-3].id", []string{"a"}, false},
+		{"$.items[-4].id", []string{}, false},
+		{"{.items[3]}", []string{}, false},
+		{"{.items[*].id}", []string{"a", "b", "c"}, false},
+		{"{.items.id}", []string{"a", "b", "c"}, false},
+		{"{.items.n}", []string{"1", "2"}, false},
+		{"{.labels.*}", []string{"first", "last"}, false},
+		{"{.labels[*]}", []string{"first", "last"}, false},
+		{"{.labels[0]}", []string{}, false},
+		{"$['dash-key']", []string{"true"}, false},
+		{"{.empty[*]}", []string{}, false},
+		{"{.unknown.field}", []string{}, false},
+		{"{}", []string{`{"dash-key":true,"empty":[],"items":[{"id":"a","n":1},{"id":"b","n":2},{"id":"c"}],"labels":{"a":"first","z":"last"}}`}, false},
+		{"{.items[x]}", nil, true},
+		{"{.items..id}", nil, true},
+	}
+	for _, tt := range tests {
+		steps, err := jsonPathParse(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("jsonPathParse(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		res, err := jsonPathEval(steps, data)
+		if err != nil {
+			t.Errorf("jsonPathEval(%q) error = %v", tt.expr, err)
+			continue
+		}
+		got := []string{}
+		for _, v := range res {
+			got = append(got, outputScalar(v))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("jsonpath %q = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestYamlScalar(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"abc", "abc"},
+		{"/home/me/prj", "/home/me/prj"},
+		{"poky-agl 5.0 (aarch64)", "poky-agl 5.0 (aarch64)"},
+		{"", `""`},
+		{"yes", `"yes"`},
+		{"No", `"No"`},
+		{"on", `"on"`},
+		{"null", `"null"`},
+		{"~", `"~"`},
+		{"1.0", `"1.0"`},
+		{"42", `"42"`},
+		{"-1", `"-1"`},
+		{".5", `".5"`},
+		{"1e10", `"1e10"`},
+		{"0x1F", `"0x1F"`},
+		{"0o17", `"0o17"`},
+		{".inf", `".inf"`},
+		{"2017-10-16", `"2017-10-16"`},
+		{"12:30", `"12:30"`},
+		{"-x", `"-x"`},
+		{"- item", `"- item"`},
+		{"a: b", `"a: b"`},
+		{"#comment", `"#comment"`},
+		{"trailing ", `"trailing "`},
+		{"line1\nline2", `"line1\nline2"`},
+		{"tab\there", `"tab\there"`},
+		{"quote\"d", `"quote\"d"`},
+	}
+	for _, tt := range tests {
+		if got := yamlScalar(tt.in); got != tt.want {
+			t.Errorf("yamlScalar(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestYamlWrite(t *testing.T) {
+	data, err := outputGeneric(map[string]interface{}{
+		"name":  "yes",
+		"ver":   "1.0",
+		"count": 3,
+		"on":    true,
+		"list":  []interface{}{"-a", map[string]interface{}{"k": "v", "n": nil}, []interface{}{}},
+		"empty": map[string]interface{}{},
+		"text":  "a\nb",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	yamlWrite(&buf, data)
+	want := `count: 3
+empty: {}
+list:
+  - "-a"
+  - k: v
+    "n": null
+  - []
+name: "yes"
+"on": true
+text: "a\nb"
+ver: "1.0"
+`
+	if buf.String() != want {
+		t.Errorf("yamlWrite() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PathMapping defines a path prefix translation
+type PathMapping struct {
+	From string
+	To   string
+}
+
+// PathRewriter rewrites path prefixes of a stream received by chunks
+type PathRewriter struct {
+	sync.Mutex
+	mappings []PathMapping // without trailing slash, longest first
+	pending  string
+	last     byte // last output character
+}
+
+// PathRewriterFlushDelay Delay after which data kept back by PathRewriter
+// (IOW the possible beginning of a path) should be output anyway (see Flush)
+const PathRewriterFlushDelay = 200 * time.Millisecond
+
+// NewPathRewriter Create a new stream rewriter, longest prefixes take precedence
+func NewPathRewriter(mappings []PathMapping) *PathRewriter {
+	r := &PathRewriter{}
+	for _, m := range mappings {
+		from := strings.TrimRight(m.From, "/")
+		to := strings.TrimRight(m.To, "/")
+		if from == "" || to == "" || from == to {
+			continue
+		}
+		r.mappings = append(r.mappings, PathMapping{From: from, To: to})
+	}
+	if len(r.mappings) == 0 {
+		return nil
+	}
+	sort.SliceStable(r.mappings, func(i, j int) bool {
+		return len(r.mappings[i].From) > len(r.mappings[j].From)
+	})
+	return r
+}
+
+// Write Rewrite a chunk of data and return the part that can be output.
+// Trailing data that may be the beginning of a path to rewrite is kept
+// until next chunk or Flush (data ending with a newline is never kept back).
+func (r *PathRewriter) Write(data string) string {
+	if r == nil || data == "" {
+		return data
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.rewrite(r.pending+data, false)
+}
+
+// Flush Return data kept back by Write (rewritten when it is a complete path)
+func (r *PathRewriter) Flush() string {
+	if r == nil {
+		return ""
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.rewrite(r.pending, true)
+}
+
+// Pending Return true when some data is kept back
+func (r *PathRewriter) Pending() bool {
+	if r == nil {
+		return false
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.pending != ""
+}
+
+// rewrite Replace mapped paths of data: a prefix is only replaced when it is
+// a complete path or is followed by a slash (e.g. /srv/prj is replaced in
+// /srv/prj, /srv/prj/x or /srv/prj:12 but not in /srv/prj2 or /x/srv/prj).
+// Unless final is set, trailing data that may be a mapped path is kept back.
+func (r *PathRewriter) rewrite(data string, final bool) string {
+	var out bytes.Buffer
+	r.pending = ""
+	i := 0
+loop:
+	for i < len(data) {
+		prev := r.last
+		if i > 0 {
+			prev = data[i-1]
+		}
+		if pathIsNameChar(prev) || prev == '/' {
+			out.WriteByte(data[i])
+			i++
+			continue
+		}
+		for _, m := range r.mappings {
+			rest := data[i:]
+			switch {
+			case strings.HasPrefix(rest, m.From):
+				end := i + len(m.From)
+				if end == len(data) && !final {
+					r.pending = rest
+					break loop
+				}
+				if end == len(data) || data[end] == '/' || !pathIsNameChar(data[end]) {
+					out.WriteString(m.To)
+					i = end
+					continue loop
+				}
+			case !final && strings.HasPrefix(m.From, rest):
+				r.pending = rest
+				break loop
+			}
+		}
+		out.WriteByte(data[i])
+		i++
+	}
+	if out.Len() > 0 {
+		r.last = out.Bytes()[out.Len()-1]
+	}
+	return out.String()
+}
+
+// pathIsNameChar Return true for characters that may be part of a file name
+func pathIsNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("._-+~@", c) >= 0
+}
+
+// PathMapPrefix Replace the From prefix of the longest matching mapping by its
+// To prefix; a prefix only matches whole path elements (e.g. /home/al matches
+// /home/al and /home/al/x but not /home/alice)
+func PathMapPrefix(p string, mappings []PathMapping) (string, bool) {
+	best := -1
+	for i, m := range mappings {
+		from := strings.TrimRight(m.From, "/")
+		if (p == from || strings.HasPrefix(p, from+"/")) && p != "" &&
+			(best < 0 || len(from) > len(strings.TrimRight(mappings[best].From, "/"))) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return p, false
+	}
+	from := strings.TrimRight(mappings[best].From, "/")
+	newPath := strings.TrimRight(mappings[best].To, "/") + p[len(from):]
+	if newPath == "" {
+		newPath = "/"
+	}
+	return newPath, true
+}
+
+// PathTranslateArgs Translate arguments that refer to a path under the From
+// prefix of a mapping, either as a plain path (/path/x), as value of a short
+// option (-I/path/x) or as value of an option or variable (--flag=/path/x)
+func PathTranslateArgs(args []string, mappings []PathMapping) []string {
+	newArgs := make([]string, len(args))
+	for i, arg := range args {
+		newArgs[i] = arg
+		for _, m := range mappings {
+			if newArg, ok := pathTranslateArg(arg, m); ok {
+				Log.Debugf("Translate argument '%s' into '%s'", arg, newArg)
+				newArgs[i] = newArg
+				break
+			}
+		}
+	}
+	return newArgs
+}
+
+func pathTranslateArg(arg string, m PathMapping) (string, bool) {
+	from := strings.TrimRight(m.From, "/")
+	to := strings.TrimRight(m.To, "/")
+	if from == "" || to == "" || from == to {
+		return arg, false
+	}
+
+	// Possible start positions of a path into the argument
+	positions := []int{0}
+	if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+		positions = append(positions, 2)
+	}
+	if idx := strings.Index(arg, "="); idx >= 0 {
+		positions = append(positions, idx+1)
+	}
+
+	for _, pos := range positions {
+		p := arg[pos:]
+		if p == from || strings.HasPrefix(p, from+"/") {
+			return arg[:pos] + to + p[len(from):], true
+		}
+	}
+	return arg, false
+}
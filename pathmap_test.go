@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPathRewriter(t *testing.T) {
+	mappings := []PathMapping{
+		{From: "/srv/prj/", To: "/home/me/prj"},
+		{From: "/srv/prj/sub", To: "/home/me/sub"},
+	}
+	tests := []struct {
+		name    string
+		chunks  []string
+		out     []string // output of each Write
+		flush   string
+		pending bool
+	}{
+		{"plain", []string{"hello\n"}, []string{"hello\n"}, "", false},
+		{"path", []string{"/srv/prj/a.c:1: error\n"}, []string{"/home/me/prj/a.c:1: error\n"}, "", false},
+		{"bare root", []string{"cd /srv/prj\n"}, []string{"cd /home/me/prj\n"}, "", false},
+		{"root with colon", []string{"/srv/prj: done\n"}, []string{"/home/me/prj: done\n"}, "", false},
+		{"longest first", []string{"/srv/prj/sub/x.c\n"}, []string{"/home/me/sub/x.c\n"}, "", false},
+		{"other name", []string{"/srv/prj2/a.c\n"}, []string{"/srv/prj2/a.c\n"}, "", false},
+		{"not at start", []string{"/x/srv/prj/a.c\n"}, []string{"/x/srv/prj/a.c\n"}, "", false},
+		{"split path", []string{"in /srv/p", "rj/a.c\n"}, []string{"in ", "/home/me/prj/a.c\n"}, "", false},
+		{"split after root", []string{"in /srv/prj", "2\n"}, []string{"in ", "/srv/prj2\n"}, "", false},
+		{"prompt kept back", []string{"Path? /sr"}, []string{"Path? "}, "/sr", true},
+		{"root kept back", []string{"at /srv/prj"}, []string{"at "}, "/home/me/prj", true},
+		{"boundary across chunks", []string{"x", "/srv/prj/a\n"}, []string{"x", "/srv/prj/a\n"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewPathRewriter(mappings)
+			for i, c := range tt.chunks {
+				if got := r.Write(c); got != tt.out[i] {
+					t.Errorf("Write(%q) = %q, want %q", c, got, tt.out[i])
+				}
+			}
+			if got := r.Pending(); got != tt.pending {
+				t.Errorf("Pending() = %v, want %v", got, tt.pending)
+			}
+			if got := r.Flush(); got != tt.flush {
+				t.Errorf("Flush() = %q, want %q", got, tt.flush)
+			}
+			if r.Pending() {
+				t.Errorf("Pending() after Flush")
+			}
+		})
+	}
+}
+
+func TestPathRewriterNoMapping(t *testing.T) {
+	r := NewPathRewriter([]PathMapping{{From: "/a", To: "/a/"}, {From: "", To: "/b"}})
+	if r != nil {
+		t.Fatalf("NewPathRewriter() = %v, want nil", r)
+	}
+	if got := r.Write("/a/x"); got != "/a/x" {
+		t.Errorf("nil Write() = %q", got)
+	}
+	if r.Pending() || r.Flush() != "" {
+		t.Errorf("nil rewriter keeps data back")
+	}
+}
+
+func TestPathTranslateArgs(t *testing.T) {
+	mappings := []PathMapping{{From: "/home/me/prj/", To: "/srv/prj"}}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"make"}, []string{"make"}},
+		{[]string{"/home/me/prj"}, []string{"/srv/prj"}},
+		{[]string{"/home/me/prj/src/a.c"}, []string{"/srv/prj/src/a.c"}},
+		{[]string{"/home/me/prj2/a.c"}, []string{"/home/me/prj2/a.c"}},
+		{[]string{"-I/home/me/prj/inc"}, []string{"-I/srv/prj/inc"}},
+		{[]string{"--prefix=/home/me/prj/out"}, []string{"--prefix=/srv/prj/out"}},
+		{[]string{"DESTDIR=/home/me/prj"}, []string{"DESTDIR=/srv/prj"}},
+		{[]string{"--x/home/me/prj"}, []string{"--x/home/me/prj"}},
+		{[]string{"x/home/me/prj/a"}, []string{"x/home/me/prj/a"}},
+	}
+	for _, tt := range tests {
+		if got := PathTranslateArgs(tt.args, mappings); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("PathTranslateArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestPathMapPrefix(t *testing.T) {
+	mappings := []PathMapping{
+		{From: "/home/al", To: "/home/bob"},
+		{From: "/home/al/prj/", To: "/work/prj"},
+		{From: "/srv", To: "/"},
+	}
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"/home/al", "/home/bob", true},
+		{"/home/al/src", "/home/bob/src", true},
+		{"/home/alice/src", "/home/alice/src", false},
+		{"/home/al/prj/x", "/work/prj/x", true},
+		{"/home/al/prj2", "/home/bob/prj2", true},
+		{"/srv", "/", true},
+		{"/srv/xds", "/xds", true},
+		{"/x/home/al", "/x/home/al", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := PathMapPrefix(tt.path, mappings)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("PathMapPrefix(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
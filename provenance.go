@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+// ProvenanceInfo Data recorded into a build provenance manifest
+type ProvenanceInfo struct {
+	Version  xaapiv1.XDSVersion
+	Project  xaapiv1.ProjectConfig
+	Sdk      xaapiv1.SDK
+	Exec     xaapiv1.ExecArgs
+	Git      *ProvenanceGit // state of sources when command was started
+	EnvClear bool           // record env values instead of their digest
+	Start    time.Time
+	End      time.Time
+	ExitCode int
+	Subjects []FetchedFile // files fetched once command succeeded
+}
+
+// ProvenanceGit Local git state of project sources
+type ProvenanceGit struct {
+	Commit string `json:"commit"`
+	Dirty  bool   `json:"dirty"`
+	Remote string `json:"remote,omitempty"`
+	Branch string `json:"branch,omitempty"`
+}
+
+// ProvenanceWrite Write a build provenance manifest using in-toto statement
+// format with a SLSA provenance v0.2 predicate; XDS specific data are set in
+// buildConfig. A sha256sum compatible file (file.sha256) is also written.
+// Values of environment variables may be secrets: only their sha256 digest is
+// recorded unless EnvClear is set.
+func ProvenanceWrite(file string, info ProvenanceInfo) error {
+	subjects := []interface{}{}
+	for _, f := range info.Subjects {
+		subjects = append(subjects, map[string]interface{}{
+			"name":   filepath.ToSlash(filepath.Join(info.Exec.RPath, f.Path)),
+			"digest": map[string]string{"sha256": f.Hash},
+		})
+	}
+
+	git := info.Git
+	execArgs := info.Exec
+	if !info.EnvClear {
+		execArgs.Env = provenanceEnvDigest(info.Exec.Env)
+	}
+	materials := []interface{}{}
+	invocation := map[string]interface{}{
+		"parameters": map[string]interface{}{
+			"cmd":   info.Exec.Cmd,
+			"args":  info.Exec.Args,
+			"rpath": info.Exec.RPath,
+			"sdkID": info.Exec.SdkID,
+		},
+		"environment": map[string]interface{}{
+			"env": execArgs.Env,
+		},
+	}
+	if git != nil {
+		uri := git.Remote
+		if uri == "" {
+			uri = "file://" + filepath.ToSlash(info.Project.ClientPath)
+		}
+		source := map[string]interface{}{
+			"uri":        "git+" + uri,
+			"digest":     map[string]string{"sha1": git.Commit},
+			"entryPoint": strings.TrimSpace(info.Exec.Cmd + " " + strings.Join(info.Exec.Args, " ")),
+		}
+		invocation["configSource"] = source
+		materials = append(materials, map[string]interface{}{
+			"uri":    source["uri"],
+			"digest": source["digest"],
+		})
+	}
+	if info.Sdk.ID != "" {
+		sdkMaterial := map[string]interface{}{"uri": "xds-sdk:" + info.Sdk.ID}
+		if info.Sdk.Md5sum != "" {
+			sdkMaterial["digest"] = map[string]string{"md5": info.Sdk.Md5sum}
+		}
+		materials = append(materials, sdkMaterial)
+	}
+
+	builderID := AppName + "@" + AppVersion
+	if len(info.Version.Server) > 0 {
+		builderID = "xds-server:" + info.Version.Server[0].ID
+	}
+	statement := map[string]interface{}{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"subject":       subjects,
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"predicate": map[string]interface{}{
+			"builder":    map[string]string{"id": builderID},
+			"buildType":  "https://github.com/iotbzh/xds-cli/exec@v1",
+			"invocation": invocation,
+			"buildConfig": map[string]interface{}{
+				"xdsVersion": info.Version,
+				"cliVersion": AppVersion + " (" + AppSubVersion + ")",
+				"project":    info.Project,
+				"sdk":        info.Sdk,
+				"exec":       execArgs,
+				"git":        git,
+				"exitCode":   info.ExitCode,
+			},
+			"metadata": map[string]interface{}{
+				"buildStartedOn":  info.Start.UTC().Format(time.RFC3339),
+				"buildFinishedOn": info.End.UTC().Format(time.RFC3339),
+				"completeness": map[string]bool{
+					"parameters":  true,
+					"environment": info.EnvClear,
+					"materials":   git != nil && !git.Dirty,
+				},
+				"reproducible": false,
+			},
+			"materials": materials,
+		},
+	}
+
+	data, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	line := hex.EncodeToString(sum[:]) + "  " + filepath.Base(file) + "\n"
+	return ioutil.WriteFile(file+".sha256", []byte(line), 0644)
+}
+
+// provenanceEnvDigest Return an environment where each value is replaced by
+// its digest (KEY=sha256:<hex>), so that values can be checked but not read
+func provenanceEnvDigest(env []string) []string {
+	digests := make([]string, 0, len(env))
+	for _, v := range env {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			digests = append(digests, v)
+			continue
+		}
+		sum := sha256.Sum256([]byte(kv[1]))
+		digests = append(digests, kv[0]+"=sha256:"+hex.EncodeToString(sum[:]))
+	}
+	return digests
+}
+
+// ProvenanceGitGet Return git state of a local directory, nil when directory
+// is not in a git repository
+func ProvenanceGitGet(dir string) *ProvenanceGit {
+	gitCmd := func(args ...string) (string, error) {
+		out, err := osexec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	commit, err := gitCmd("rev-parse", "HEAD")
+	if err != nil {
+		Log.Debugf("No git information for %s: %v", dir, err)
+		return nil
+	}
+	git := &ProvenanceGit{Commit: commit}
+	if status, err := gitCmd("status", "--porcelain", "--untracked-files=no"); err != nil || status != "" {
+		git.Dirty = true
+	}
+	git.Remote, _ = gitCmd("config", "--get", "remote.origin.url")
+	if u, err := url.Parse(git.Remote); err == nil && u.User != nil {
+		// Don't record credentials set in remote url
+		u.User = nil
+		git.Remote = u.String()
+	}
+	if branch, err := gitCmd("rev-parse", "--abbrev-ref", "HEAD"); err == nil && branch != "HEAD" {
+		git.Branch = branch
+	}
+	return git
+}
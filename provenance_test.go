@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestProvenanceEnvDigest(t *testing.T) {
+	tests := []struct {
+		env  []string
+		want []string
+	}{
+		{nil, []string{}},
+		{[]string{"CC=gcc"}, []string{"CC=sha256:94f0fa7f897ccce65856dc5a98bae4bf6957a346766613d79414c976d093aa4a"}},
+		{[]string{"EMPTY="}, []string{"EMPTY=sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}},
+		{[]string{"A=x=y", "B"}, []string{"A=sha256:14bf50d80768f3bcd08b39d302f7e14465ae44bf65e7ef88bb28e83e8a1ae172", "B"}},
+	}
+	for _, tt := range tests {
+		if got := provenanceEnvDigest(tt.env); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("provenanceEnvDigest(%q) = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestProvenanceWriteEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds-provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := []string{"CC=gcc", "TOKEN=secret", "EMPTY="}
+	tests := []struct {
+		clear bool
+		want  []string
+	}{
+		{false, provenanceEnvDigest(env)},
+		{true, env},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(dir, "prov.json")
+		info := ProvenanceInfo{Exec: xaapiv1.ExecArgs{Cmd: "make", Env: env}, EnvClear: tt.clear}
+		if err := ProvenanceWrite(file, info); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := struct {
+			Predicate struct {
+				Invocation struct {
+					Environment struct {
+						Env []string `json:"env"`
+					} `json:"environment"`
+				} `json:"invocation"`
+				BuildConfig struct {
+					Exec xaapiv1.ExecArgs `json:"exec"`
+				} `json:"buildConfig"`
+			} `json:"predicate"`
+		}{}
+		if err := json.Unmarshal(data, &st); err != nil {
+			t.Fatal(err)
+		}
+		// Every variable is recorded, values in clear only when requested
+		if got := st.Predicate.Invocation.Environment.Env; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("clear=%v: invocation env = %q, want %q", tt.clear, got, tt.want)
+		}
+		if got := st.Predicate.BuildConfig.Exec.Env; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("clear=%v: buildConfig exec env = %q, want %q", tt.clear, got, tt.want)
+		}
+	}
+}
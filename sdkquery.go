@@ -0,0 +1,276 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+	"github.com/urfave/cli"
+)
+
+// sdkCriterion One criterion of a SDK query (e.g. version>=5.0)
+type sdkCriterion struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+var sdkCriterionRe = regexp.MustCompile(`^\s*(\w+)\s*(>=|<=|!=|=|>|<|~)\s*(.*?)\s*$`)
+
+// SdkQueryIs Return true when a SDK reference is a query (for example
+// arch=aarch64,profile=agl-demo,version>=5.0) rather than an ID
+func SdkQueryIs(ref string) bool {
+	return strings.ContainsAny(ref, "=<>~")
+}
+
+// sdkQueryParse Parse a comma separated list of criteria
+func sdkQueryParse(query string) ([]sdkCriterion, error) {
+	criteria := []sdkCriterion{}
+	for _, c := range strings.Split(query, ",") {
+		m := sdkCriterionRe.FindStringSubmatch(c)
+		if m == nil {
+			return nil, fmt.Errorf("invalid SDK query criterion '%s' (e.g. arch=aarch64 or version>=5.0)", c)
+		}
+		crit := sdkCriterion{field: strings.ToLower(m[1]), op: m[2], value: m[3]}
+		if _, err := sdkField(xaapiv1.SDK{}, crit.field); err != nil {
+			return nil, err
+		}
+		if crit.op == "~" {
+			re, err := regexp.Compile(crit.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regexp in SDK query criterion '%s': %v", c, err)
+			}
+			crit.re = re
+		}
+		criteria = append(criteria, crit)
+	}
+	return criteria, nil
+}
+
+func sdkField(s xaapiv1.SDK, field string) (string, error) {
+	switch field {
+	case "id":
+		return s.ID, nil
+	case "name":
+		return s.Name, nil
+	case "profile":
+		return s.Profile, nil
+	case "version":
+		return s.Version, nil
+	case "arch":
+		return s.Arch, nil
+	}
+	return "", fmt.Errorf("unknown SDK query field '%s' (id, name, profile, version or arch)", field)
+}
+
+// match Return true when a SDK matches criterion: = and != are case
+// insensitive comparisons, ~ is a regexp match and other operators compare
+// versions (e.g. 4.99.5 < 5.0)
+func (c sdkCriterion) match(s xaapiv1.SDK) bool {
+	val, _ := sdkField(s, c.field)
+	switch c.op {
+	case "=":
+		return strings.EqualFold(val, c.value)
+	case "!=":
+		return !strings.EqualFold(val, c.value)
+	case "~":
+		return c.re.MatchString(val)
+	}
+	cmp := VersionCompare(val, c.value)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// versionPart Numeric or alphabetic part of a version and separator before it
+type versionPart struct {
+	str   string
+	num   int
+	isNum bool
+	sep   rune
+}
+
+// versionSplit Split a version on separators (. - _ +) and between digits
+// and letters, e.g. 5.0-rc10 gives 5, 0, rc, 10
+func versionSplit(v string) []versionPart {
+	parts := []versionPart{}
+	var sep rune
+	for _, r := range v {
+		if r == '.' || r == '-' || r == '_' || r == '+' {
+			sep = r
+			continue
+		}
+		isNum := r >= '0' && r <= '9'
+		if n := len(parts); sep == 0 && n > 0 && parts[n-1].isNum == isNum {
+			parts[n-1].str += string(r)
+		} else {
+			parts = append(parts, versionPart{str: string(r), isNum: isNum, sep: sep})
+		}
+		sep = 0
+	}
+	for i := range parts {
+		if parts[i].isNum {
+			parts[i].num, _ = strconv.Atoi(parts[i].str)
+		}
+	}
+	return parts
+}
+
+// VersionCompare Compare two versions made of numeric and alphabetic parts,
+// returns -1, 0 or 1. Missing parts are 0 (5.0 is 5.0.0) and, like semver, an
+// alphabetic part is a pre-release (5.0-rc1 < 5.0) unless it follows a + (5.0
+// < 5.0+git)
+func VersionCompare(a, b string) int {
+	pa, pb := versionSplit(a), versionSplit(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		ea, eb := versionPart{isNum: true}, versionPart{isNum: true}
+		if i < len(pa) {
+			ea = pa[i]
+		}
+		if i < len(pb) {
+			eb = pb[i]
+		}
+		if c := versionPartCompare(ea, eb); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func versionPartCompare(a, b versionPart) int {
+	switch {
+	case a.isNum && b.isNum:
+		if a.num < b.num {
+			return -1
+		} else if a.num > b.num {
+			return 1
+		}
+		return 0
+	case !a.isNum && !b.isNum:
+		return strings.Compare(a.str, b.str)
+	case b.isNum:
+		if b.num > 0 || a.sep != '+' {
+			return -1
+		}
+		return 1
+	}
+	return -versionPartCompare(b, a)
+}
+
+// SdkQueryMatch Return installed SDKs matching all criteria of a query
+func SdkQueryMatch(query string, sdks []xaapiv1.SDK) ([]xaapiv1.SDK, error) {
+	criteria, err := sdkQueryParse(query)
+	if err != nil {
+		return nil, err
+	}
+	found := []xaapiv1.SDK{}
+	for _, s := range sdks {
+		if s.Status != xaapiv1.SdkStatusInstalled {
+			continue
+		}
+		ok := true
+		for _, c := range criteria {
+			if !c.match(s) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+// SdkSelect Return the SDK to use for a project: the SDK set by ref (either
+// an ID, an ID prefix, a name or a query), else default SDK of project, else
+// the only installed SDK. An empty SDK is returned when no SDK is installed,
+// an error listing candidates when several ones are installed.
+func SdkSelect(ref string, prj xaapiv1.ProjectConfig) (xaapiv1.SDK, error) {
+	if ref != "" && !SdkQueryIs(ref) {
+		id, err := SdkIDResolve(ref)
+		return xaapiv1.SDK{ID: id}, err
+	}
+	if ref == "" && prj.DefaultSdk != "" {
+		Log.Infof("Use default SDK %s of project %s", prj.DefaultSdk, prj.ID)
+		return xaapiv1.SDK{ID: prj.DefaultSdk}, nil
+	}
+
+	sdks := []xaapiv1.SDK{}
+	if err := _sdksListGet(&sdks); err != nil {
+		return xaapiv1.SDK{}, err
+	}
+	query := ref
+	if query == "" {
+		query = "id~."
+	}
+	found, err := SdkQueryMatch(query, sdks)
+	if err != nil {
+		return xaapiv1.SDK{}, cli.NewExitError(err.Error(), ExitCodeInvalidArgs)
+	}
+
+	switch {
+	case len(found) == 1:
+		Log.Infof("Use SDK %s (%s) matching '%s'", found[0].ID, found[0].Name, query)
+		return found[0], nil
+	case ref == "" && len(found) == 0:
+		Log.Infof("No installed SDK, use server default")
+		return xaapiv1.SDK{}, nil
+	case ref == "":
+		return xaapiv1.SDK{}, cli.NewExitError(fmt.Sprintf("several SDKs are installed and project has no default SDK, please set one using --sdkid option%s",
+			_sdksCandidates("installed SDKs", found, false)), ExitCodeInvalidArgs)
+	case len(found) == 0:
+		return xaapiv1.SDK{}, cli.NewExitError(fmt.Sprintf("no installed SDK matches '%s'%s",
+			ref, _sdksCandidates("installed SDKs", sdks, true)), ExitCodeNotFound)
+	}
+	return xaapiv1.SDK{}, cli.NewExitError(fmt.Sprintf("several installed SDKs match '%s', please refine query%s",
+		ref, _sdksCandidates("matching SDKs", found, false)), ExitCodeInvalidArgs)
+}
+
+// _sdksCandidates Return a displayable list of SDKs
+func _sdksCandidates(title string, sdks []xaapiv1.SDK, installedOnly bool) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\n%s:\n", title)
+	writer := new(tabwriter.Writer)
+	writer.Init(&buf, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(writer, "  ID\t NAME\t PROFILE\t VERSION\t ARCH")
+	for _, s := range sdks {
+		if installedOnly && s.Status != xaapiv1.SdkStatusInstalled {
+			continue
+		}
+		fmt.Fprintln(writer, "  "+_shortID(s.ID), "\t", s.Name, "\t", s.Profile, "\t", s.Version, "\t", s.Arch)
+	}
+	writer.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
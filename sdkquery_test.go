@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iotbzh/xds-agent/lib/xaapiv1"
+)
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"5.0", "5.0", 0},
+		{"5.0", "5.0.0", 0},
+		{"4.99.5", "5.0", -1},
+		{"5.10", "5.9", 1},
+		{"5.0-rc1", "5.0-rc2", -1},
+		{"5.0-rc9", "5.0-rc10", -1},
+		{"5.0-rc1", "5.0", -1},
+		{"5.0", "5.0-rc1", 1},
+		{"5.0-rc1", "5.0.0", -1},
+		{"5.0-beta2", "5.0-rc1", -1},
+		{"5.0rc1", "5.0", -1},
+		{"5.0-rc1", "4.99.5", 1},
+		{"5.0-1", "5.0-rc1", 1},
+		{"5.0.1", "5.0-rc1", 1},
+		{"5.0+git", "5.0", 1},
+		{"5.0+git", "5.0.0", 1},
+		{"5.0+git", "5.0.1", -1},
+		{"", "0", 0},
+	}
+	for _, tt := range tests {
+		if got := VersionCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("VersionCompare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSdkQueryMatch(t *testing.T) {
+	sdks := []xaapiv1.SDK{
+		{ID: "aaa1", Name: "poky-agl-aarch64-4.99.5", Profile: "poky-agl", Version: "4.99.5", Arch: "aarch64", Status: xaapiv1.SdkStatusInstalled},
+		{ID: "bbb2", Name: "poky-agl-aarch64-5.0", Profile: "poky-agl", Version: "5.0", Arch: "aarch64", Status: xaapiv1.SdkStatusInstalled},
+		{ID: "ccc3", Name: "poky-agl-x86-64-5.0", Profile: "poky-agl", Version: "5.0", Arch: "x86-64", Status: xaapiv1.SdkStatusInstalled},
+		{ID: "ddd4", Name: "poky-agl-aarch64-6.0", Profile: "poky-agl", Version: "6.0", Arch: "aarch64", Status: xaapiv1.SdkStatusNotInstalled},
+		{ID: "eee5", Name: "poky-agl-aarch64-5.0-rc1", Profile: "poky-agl", Version: "5.0-rc1", Arch: "aarch64", Status: xaapiv1.SdkStatusInstalled},
+	}
+	tests := []struct {
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{"arch=aarch64", []string{"aaa1", "bbb2", "eee5"}, false},
+		{"arch=AARCH64,version>=5.0", []string{"bbb2"}, false},
+		{"version>=5.0-rc1,version<5.0", []string{"eee5"}, false},
+		{"version<5", []string{"aaa1", "eee5"}, false},
+		{"arch!=aarch64", []string{"ccc3"}, false},
+		{"name~x86", []string{"ccc3"}, false},
+		{"version>6", []string{}, false},
+		{"id~.", []string{"aaa1", "bbb2", "ccc3", "eee5"}, false},
+		{"color=red", nil, true},
+		{"name~[", nil, true},
+		{"arch", nil, true},
+	}
+	for _, tt := range tests {
+		found, err := SdkQueryMatch(tt.query, sdks)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SdkQueryMatch(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		ids := []string{}
+		for _, s := range found {
+			ids = append(ids, s.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("SdkQueryMatch(%q) = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
+
+func TestSdkQueryIs(t *testing.T) {
+	for ref, want := range map[string]bool{
+		"aaa1":                 false,
+		"poky-agl-aarch64-5.0": false,
+		"arch=aarch64":         true,
+		"version>=5.0":         true,
+		"name~agl":             true,
+	} {
+		if got := SdkQueryIs(ref); got != want {
+			t.Errorf("SdkQueryIs(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
@@ -19,10 +19,14 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/franciscocpg/reflectme"
 	"github.com/iotbzh/xds-agent/lib/xaapiv1"
@@ -107,6 +111,20 @@ func LogPost(format string, data interface{}) {
 	Log.Infof(format, string(b))
 }
 
+// ProjectIDResolve Return ID of a project referenced either by its ID, an
+// unique prefix of its ID or its label
+func ProjectIDResolve(ref string) (string, error) {
+	prjs := []xaapiv1.ProjectConfig{}
+	if err := ProjectsListGet(&prjs); err != nil {
+		return "", err
+	}
+	items := []IDItem{}
+	for _, p := range prjs {
+		items = append(items, IDItem{ID: p.ID, Name: p.Label})
+	}
+	return ResolveID("project", ref, items)
+}
+
 // GetID Return a string ID set with --id option or as simple parameter
 func GetID(ctx *cli.Context) string {
 	id := ctx.String("id")
@@ -117,11 +135,158 @@ func GetID(ctx *cli.Context) string {
 	return id
 }
 
+// GetIDResolved Return the ID set with --id option or as simple parameter,
+// after resolution of a prefix or a name using resolve function
+func GetIDResolved(ctx *cli.Context, resolve func(string) (string, error)) (string, error) {
+	id := GetID(ctx)
+	if id == "" {
+		return "", cli.NewExitError("id parameter or option must be set", ExitCodeInvalidArgs)
+	}
+	return resolve(id)
+}
+
+// IDItem Object that can be referenced either by its ID or by its name
+type IDItem struct {
+	ID   string
+	Name string
+}
+
+// ResolveID Return ID of the item referenced by ref: either a full ID, an
+// exact name or an unique prefix of an ID (kind is only used by messages)
+func ResolveID(kind, ref string, items []IDItem) (string, error) {
+	byName := []IDItem{}
+	byPrefix := []IDItem{}
+	for _, it := range items {
+		if it.ID == ref {
+			return it.ID, nil
+		}
+		if it.Name != "" && it.Name == ref {
+			byName = append(byName, it)
+		}
+		if strings.HasPrefix(it.ID, ref) {
+			byPrefix = append(byPrefix, it)
+		}
+	}
+
+	found := byName
+	if len(found) == 0 {
+		found = byPrefix
+	}
+	switch len(found) {
+	case 0:
+		return "", cli.NewExitError(fmt.Sprintf("unknown %s '%s'", kind, ref), ExitCodeNotFound)
+	case 1:
+		Log.Debugf("%s '%s' resolved to %s", kind, ref, found[0].ID)
+		return found[0].ID, nil
+	}
+	msg := fmt.Sprintf("ambiguous %s '%s', candidates are:", kind, ref)
+	for _, it := range found {
+		msg += "\n  " + it.ID
+		if it.Name != "" {
+			msg += "  " + it.Name
+		}
+	}
+	return "", cli.NewExitError(msg, ExitCodeInvalidArgs)
+}
+
+// stdinReader Buffered reader shared by all reads of user answers on stdin
+// (using several readers would lose input buffered by another one)
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// StdinReadLine Read a line of user input, returned without spaces around
+func StdinReadLine() string {
+	line, _ := stdinReader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
 // Confirm Return true when user answer 'y' or 'yes' to a question
 func Confirm(question string) bool {
-	var answer string
 	fmt.Print(question)
-	fmt.Scanln(&answer)
-	ans := strings.ToLower(strings.TrimSpace(answer))
+	ans := strings.ToLower(StdinReadLine())
 	return (ans == "y" || ans == "yes")
 }
+
+// Prompt Ask a question and return user answer, or def when answer is empty
+func Prompt(question, def string) string {
+	if def != "" {
+		question += " [" + def + "]"
+	}
+	fmt.Print(question + ": ")
+	answer := StdinReadLine()
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// ParseTimeout Return the duration set by a timeout option, a number without
+// unit is a number of seconds and 0, "none" or "unlimited" means no timeout
+func ParseTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "", "0", "none", "unlimited":
+		return 0, nil
+	}
+	if sec, err := strconv.Atoi(value); err == nil {
+		value = strconv.Itoa(sec) + "s"
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout value '%s' (e.g. 90, 45m or 1h30m)", value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid negative timeout value '%s'", value)
+	}
+	return d, nil
+}
+
+// DurationRound Return d rounded to the nearest multiple of m (same as
+// Duration.Round that is not available with Go 1.8)
+func DurationRound(d, m time.Duration) time.Duration {
+	if m <= 0 {
+		return d
+	}
+	if d < 0 {
+		return -DurationRound(-d, m)
+	}
+	return (d + m/2) / m * m
+}
+
+// StateDirGet Return (and create when needed) a sub-directory of local state
+// directory: either XDS_STATE_DIR or $XDG_STATE_HOME/xds-cli or $HOME/.local/state/xds-cli
+func StateDirGet(sub ...string) (string, error) {
+	dir := os.Getenv("XDS_STATE_DIR")
+	if dir == "" {
+		if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+			dir = filepath.Join(xdg, AppName)
+		} else {
+			home := os.Getenv("HOME")
+			if home == "" {
+				home = os.Getenv("USERPROFILE")
+			}
+			if home == "" {
+				return "", fmt.Errorf("cannot determine state directory, please set XDS_STATE_DIR")
+			}
+			dir = filepath.Join(home, ".local", "state", AppName)
+		}
+	}
+	dir = filepath.Join(append([]string{dir}, sub...)...)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// IsTerminal Return true when file is a terminal (IOW a character device)
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return (fi.Mode() & os.ModeCharDevice) != 0
+}
+
+// ShellQuote Quote a string to be used as a single shell word
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
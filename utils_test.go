@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2017 "IoT.bzh"
+ * Author Sebastien Douheret <sebastien@iot.bzh>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"none", 0, false},
+		{" Unlimited ", 0, false},
+		{"90", 90 * time.Second, false},
+		{"45m", 45 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"-5", 0, true},
+		{"-1m", 0, true},
+		{"10 minutes", 0, true},
+		{"1.5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimeout(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTimeout(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTimeout(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestResolveID(t *testing.T) {
+	items := []IDItem{
+		{ID: "4f3c2a10-aaaa", Name: "app"},
+		{ID: "4f3c9b77-bbbb", Name: "lib"},
+		{ID: "7d01e5c2-cccc", Name: "4f3c"},
+		{ID: "90ab12cd-dddd", Name: "dup"},
+		{ID: "90ab34ef-eeee", Name: "dup"},
+	}
+	tests := []struct {
+		ref      string
+		want     string
+		wantCode int
+	}{
+		{"4f3c2a10-aaaa", "4f3c2a10-aaaa", 0},
+		{"lib", "4f3c9b77-bbbb", 0},
+		{"4f3c9", "4f3c9b77-bbbb", 0},
+		{"7d", "7d01e5c2-cccc", 0},
+		// Name is preferred over ID prefix
+		{"4f3c", "7d01e5c2-cccc", 0},
+		{"4f", "", ExitCodeInvalidArgs},
+		{"dup", "", ExitCodeInvalidArgs},
+		{"90ab", "", ExitCodeInvalidArgs},
+		{"", "", ExitCodeInvalidArgs},
+		{"zzz", "", ExitCodeNotFound},
+		{"4f3c2a10-aaaa-x", "", ExitCodeNotFound},
+	}
+	for _, tt := range tests {
+		got, err := ResolveID("project", tt.ref, items)
+		if tt.wantCode == 0 {
+			if err != nil || got != tt.want {
+				t.Errorf("ResolveID(%q) = %q, %v, want %q", tt.ref, got, err, tt.want)
+			}
+			continue
+		}
+		ec, ok := err.(cli.ExitCoder)
+		if !ok || ec.ExitCode() != tt.wantCode {
+			t.Errorf("ResolveID(%q) = %q, %v, want exit code %d", tt.ref, got, err, tt.wantCode)
+		}
+	}
+
+	if _, err := ResolveID("sdk", "x", nil); err == nil {
+		t.Errorf("ResolveID on empty list should fail")
+	}
+}
+
+func TestDurationRound(t *testing.T) {
+	tests := []struct {
+		d, m time.Duration
+		want time.Duration
+	}{
+		{1499 * time.Millisecond, time.Second, time.Second},
+		{1500 * time.Millisecond, time.Second, 2 * time.Second},
+		{-1500 * time.Millisecond, time.Second, -2 * time.Second},
+		{1234567 * time.Microsecond, time.Millisecond, 1235 * time.Millisecond},
+		{42 * time.Second, 0, 42 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := DurationRound(tt.d, tt.m); got != tt.want {
+			t.Errorf("DurationRound(%v, %v) = %v, want %v", tt.d, tt.m, got, tt.want)
+		}
+	}
+}
+
+// testSetenv Set an environment variable, returned function restores it
+func testSetenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// testTempDir Create a temporary directory, returned function removes it
+func testTempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "xds-cli-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}